@@ -0,0 +1,165 @@
+package testmatrix
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Addrs returns n free loopback addresses of the form "127.0.0.1:port" for
+// the fixture of t, which must be a test started by Runner.Run or Runner.RunE,
+// or a subtest of one. The addresses do not collide with any others allocated
+// in the same run, and are held until the fixture has been torn down.
+//
+// Ports are found to be free by briefly listening on them, so processes
+// outside the run could still take them in the meantime.
+func Addrs(t *testing.T, n int) []string {
+	t.Helper()
+	owner, a, err := addrOwner(t.Name())
+	if err != nil {
+		t.Fatalf("allocating addresses: %s", err)
+	}
+	addrs := make([]string, n)
+	for i := range addrs {
+		port, err := a.allocatePort(owner)
+		if err != nil {
+			t.Fatalf("allocating addresses: %s", err)
+		}
+		addrs[i] = net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	}
+	return addrs
+}
+
+// LoopbackIP returns an IP address from 127.0.0.0/8, other than 127.0.0.1,
+// for the fixture of t to use exclusively, so that it can listen on
+// well-known ports without colliding with other fixtures. Every call for the
+// same fixture returns the same IP, which is held until the fixture has been
+// torn down.
+//
+// This relies on the whole of 127.0.0.0/8 being routed to the loopback
+// interface, as on Linux. Elsewhere, such as on macOS, each address must be
+// added as an alias first; if the IP cannot be listened on, t fails.
+func LoopbackIP(t *testing.T) string {
+	t.Helper()
+	owner, a, err := addrOwner(t.Name())
+	if err != nil {
+		t.Fatalf("allocating loopback IP: %s", err)
+	}
+	ip, err := a.allocateIP(owner)
+	if err != nil {
+		t.Fatalf("allocating loopback IP: %s", err)
+	}
+	return ip
+}
+
+// addrOwner returns the owner of addresses allocated by the named test, and
+// the allocator to use.
+func addrOwner(name string) (string, *addrAllocator, error) {
+	_, rt, err := lookupRunningTest(name)
+	if err != nil {
+		return "", nil, err
+	}
+	return ownerOf(rt), rt.sup.addrs, nil
+}
+
+// owner returns the owner of addresses allocated while building sf.
+func (sf *sharedFixture) owner() string {
+	return fmt.Sprintf("shared:%p", sf)
+}
+
+// addrAllocator hands out loopback ports and IPs, ensuring each is only held
+// by one owner at a time.
+type addrAllocator struct {
+	mu sync.Mutex
+	// ports maps each allocated port to its owner.
+	ports map[int]string
+	// ips maps each owner holding a loopback IP to that IP.
+	ips map[string]string
+	// freeIPs are IPs released by their owners, to be reused.
+	freeIPs []string
+	// nextIP is the index in 127.0.0.0/8 of the next IP never allocated.
+	nextIP int
+}
+
+func newAddrAllocator() *addrAllocator {
+	return &addrAllocator{
+		ports: map[int]string{},
+		ips:   map[string]string{},
+		// Start at 127.0.1.1, leaving 127.0.0.0/24 alone.
+		nextIP: 1<<8 + 1,
+	}
+}
+
+// allocatePort returns a free port on 127.0.0.1 for owner, which is not held
+// by any other owner.
+func (a *addrAllocator) allocatePort(owner string) (int, error) {
+	// The OS may hand out a port we have allocated but whose owner is not
+	// yet listening on it, so try a few times.
+	for i := 0; i < 100; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return 0, err
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+		a.mu.Lock()
+		if _, taken := a.ports[port]; !taken {
+			a.ports[port] = owner
+			a.mu.Unlock()
+			return port, nil
+		}
+		a.mu.Unlock()
+	}
+	return 0, fmt.Errorf("no free port found")
+}
+
+// allocateIP returns the loopback IP held by owner, allocating one if it has
+// none.
+func (a *addrAllocator) allocateIP(owner string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if ip, ok := a.ips[owner]; ok {
+		return ip, nil
+	}
+	var ip string
+	if n := len(a.freeIPs); n != 0 {
+		ip, a.freeIPs = a.freeIPs[n-1], a.freeIPs[:n-1]
+	} else {
+		for ip == "" {
+			i := a.nextIP
+			a.nextIP++
+			if i >= 1<<24 {
+				return "", fmt.Errorf("no free loopback IPs")
+			}
+			// Avoid network and broadcast-like addresses.
+			if i&255 != 0 && i&255 != 255 {
+				ip = fmt.Sprintf("127.%d.%d.%d", i>>16&255, i>>8&255, i&255)
+			}
+		}
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		a.freeIPs = append(a.freeIPs, ip)
+		return "", fmt.Errorf("%s is not usable; it may need adding as a loopback alias: %w", ip, err)
+	}
+	l.Close()
+	a.ips[owner] = ip
+	return ip, nil
+}
+
+// release releases all ports and IPs held by owner.
+func (a *addrAllocator) release(owner string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for port, o := range a.ports {
+		if o == owner {
+			delete(a.ports, port)
+		}
+	}
+	if ip, ok := a.ips[owner]; ok {
+		delete(a.ips, owner)
+		a.freeIPs = append(a.freeIPs, ip)
+	}
+}
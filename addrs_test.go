@@ -0,0 +1,105 @@
+package testmatrix
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAddrAllocator_ports(t *testing.T) {
+	t.Parallel()
+	a := newAddrAllocator()
+	seen := map[int]bool{}
+	for _, owner := range []string{"a", "a", "b"} {
+		port, err := a.allocatePort(owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[port] {
+			t.Errorf("port %d allocated twice", port)
+		}
+		seen[port] = true
+	}
+	a.release("a")
+	if len(a.ports) != 1 {
+		t.Errorf("got %d ports held after releasing a; want 1", len(a.ports))
+	}
+}
+
+func TestAddrAllocator_ips(t *testing.T) {
+	t.Parallel()
+	a := newAddrAllocator()
+	ipA, err := a.allocateIP("a")
+	if err != nil {
+		t.Skipf("loopback IPs not supported here: %s", err)
+	}
+	if ipA != "127.0.1.1" {
+		t.Errorf("got first IP %s; want 127.0.1.1", ipA)
+	}
+	if again, _ := a.allocateIP("a"); again != ipA {
+		t.Errorf("got IP %s for the same owner; want %s", again, ipA)
+	}
+	ipB, _ := a.allocateIP("b")
+	if ipB == ipA {
+		t.Errorf("got IP %s for two owners", ipA)
+	}
+	a.release("a")
+	if ipC, _ := a.allocateIP("c"); ipC != ipA {
+		t.Errorf("got IP %s after release; want released %s", ipC, ipA)
+	}
+}
+
+// addrsFixture records whether its addresses were still held when it was
+// torn down.
+type addrsFixture struct {
+	addrs          *addrAllocator
+	owner          string
+	heldAtTeardown bool
+}
+
+func (f *addrsFixture) Teardown(*testing.T) {
+	f.addrs.mu.Lock()
+	defer f.addrs.mu.Unlock()
+	for _, o := range f.addrs.ports {
+		if o == f.owner {
+			f.heldAtTeardown = true
+		}
+	}
+}
+
+func TestAddrs(t *testing.T) {
+	t.Parallel()
+	for _, options := range [][]RunOption{nil, {Shared()}} {
+		m := New(makeTestDim(1, 4))
+		var mu sync.Mutex
+		seen := map[string]bool{}
+		var fixtures []*addrsFixture
+		runGroup(t, "run", func(t *testing.T) {
+			r := m.NewRunner(t)
+			for _, name := range []string{"one", "two"} {
+				r.Run(name, func(t *testing.T, s Scenario) Fixture {
+					addrs := Addrs(t, 2)
+					_, rt, _ := lookupRunningTest(t.Name())
+					f := &addrsFixture{addrs: m.sup.addrs, owner: ownerOf(rt)}
+					mu.Lock()
+					defer mu.Unlock()
+					fixtures = append(fixtures, f)
+					for _, a := range addrs {
+						if seen[a] {
+							t.Errorf("address %s allocated twice", a)
+						}
+						seen[a] = true
+					}
+					return f
+				}, func(*testing.T, Fixture) {}, options...)
+			}
+		})
+		for _, f := range fixtures {
+			if !f.heldAtTeardown {
+				t.Errorf("addresses of %s released before teardown", f.owner)
+			}
+		}
+		if n := len(m.sup.addrs.ports); n != 0 {
+			t.Errorf("got %d ports still held after the run; want 0", n)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package testmatrix
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// artifactRecord is an artifact directory created for a test.
+type artifactRecord struct {
+	dir      string
+	scenario Scenario
+}
+
+// ArtifactDir returns the artifact directory of t, creating it if necessary.
+// Anything written there is kept after the run, and bundled if t fails.
+// t must be a test started by Runner.Run or Runner.RunE, or a subtest of
+// one, so ArtifactDir can be called both from fixture factories and tests.
+//
+// Artifact directories are organised by top-level test, scenario path and
+// subtest, under -tm.artifacts, Opts.ArtifactsDir, or a new temporary
+// directory.
+func ArtifactDir(t *testing.T) string {
+	t.Helper()
+	dir, err := artifactDir(t.Name())
+	if err != nil {
+		t.Fatalf("getting artifact directory: %s", err)
+	}
+	return dir
+}
+
+// artifactDir returns the artifact directory of the named test, creating it
+// if necessary.
+func artifactDir(name string) (string, error) {
+	key, at, err := lookupRunningTest(name)
+	if err != nil {
+		return "", err
+	}
+	root, err := at.sup.artifactsRoot()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(root, artifactPath(name))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	at.sup.artifactsMu.Lock()
+	defer at.sup.artifactsMu.Unlock()
+	at.sup.artifacts[key] = artifactRecord{
+		dir:      filepath.Join(root, artifactPath(key)),
+		scenario: at.scenario,
+	}
+	return dir, nil
+}
+
+// artifactPath converts a test name to a relative path, replacing characters
+// which are not allowed in file names on some systems.
+func artifactPath(name string) string {
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		p = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`\:*?"<>|`, r) || r < ' ' {
+				return '_'
+			}
+			return r
+		}, p)
+		if p == "" || p == "." || p == ".." {
+			p = "_" + p
+		}
+		parts[i] = p
+	}
+	return filepath.Join(parts...)
+}
+
+// artifactsRoot returns the directory containing all artifact directories,
+// creating it on first use.
+func (s *supervisor) artifactsRoot() (string, error) {
+	s.artifactsOnce.Do(func() {
+		switch {
+		case *artifactsFlag != "":
+			s.artifactsDir = *artifactsFlag
+		case opts.ArtifactsDir != "":
+			s.artifactsDir = opts.ArtifactsDir
+		default:
+			s.artifactsDir, s.artifactsErr = os.MkdirTemp("", "testmatrix-artifacts-")
+			s.artifactsTemp = s.artifactsErr == nil
+			return
+		}
+		s.artifactsErr = os.MkdirAll(s.artifactsDir, 0755)
+	})
+	return s.artifactsDir, s.artifactsErr
+}
+
+// bundleAll returns true if all artifact directories should be bundled,
+// rather than just those of failed tests.
+func bundleAll() bool {
+	return *bundleAllFlag || opts.BundleAll
+}
+
+// testStatus returns the status of the named test, as shown in the summary.
+func (s *supervisor) testStatus(name string) string {
+	for _, pf := range s.fixtures {
+		if status, ok := pf.testStatus(name); ok {
+			return status
+		}
+	}
+	return "MISSING"
+}
+
+// bundleArtifacts writes the artifact directories of tests which did not pass
+// or skip, or of all tests if bundleAll, to a tar.gz file along with an index
+// mapping each directory to its scenario and status. It returns the path of
+// the file, or "" if there was nothing to bundle, in which case a temporary
+// artifacts directory is removed.
+func (s *supervisor) bundleArtifacts() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.artifactsMu.Lock()
+	defer s.artifactsMu.Unlock()
+	var names []string
+	var index strings.Builder
+	for name := range s.artifacts {
+		status := s.testStatus(name)
+		if !bundleAll() && (status == "PASSED" || status == "SKIPPED") {
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		if s.artifactsTemp {
+			return "", os.RemoveAll(s.artifactsDir)
+		}
+		return "", nil
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&index, "%s\t%s\t%s\n", filepath.ToSlash(artifactPath(name)),
+			s.artifacts[name].scenario, s.testStatus(name))
+	}
+	path := *bundleFlag
+	if path == "" {
+		path = filepath.Join(s.artifactsDir, "artifacts.tar.gz")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     "index.txt",
+		Mode:     0644,
+		Size:     int64(index.Len()),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return "", err
+	}
+	if _, err := io.WriteString(tw, index.String()); err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		if err := addToTar(tw, s.artifactsDir, s.artifacts[name].dir); err != nil {
+			return "", fmt.Errorf("adding artifacts of %s: %w", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	s.bundle = path
+	return path, nil
+}
+
+// addToTar adds dir and everything in it to tw, named relative to root.
+func addToTar(tw *tar.Writer, root, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		var link string
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case !info.Mode().IsRegular() && !info.IsDir():
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// BundleArtifacts writes the artifact directories of failed tests, or of all
+// tests with -tm.bundle-all or Opts.BundleAll, to a tar.gz file including an
+// index.txt mapping each directory to its scenario and status. The file is
+// written to -tm.bundle, or artifacts.tar.gz in the artifacts directory.
+// It returns the path of the file, or "" if there was nothing to bundle. If
+// there was nothing to bundle and the artifacts directory is a new temporary
+// directory, it is removed. It must be called after all tests and teardowns
+// have finished.
+//
+// If using the Run func, you don't need to additionally call this.
+func (m *Matrix) BundleArtifacts() (string, error) {
+	return m.sup.bundleArtifacts()
+}
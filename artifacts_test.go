@@ -0,0 +1,155 @@
+package testmatrix
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestArtifactPath(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name, want string
+	}{
+		{"TestA/x/y/one", filepath.Join("TestA", "x", "y", "one")},
+		{"TestA/a:b/c*d", filepath.Join("TestA", "a_b", "c_d")},
+		{"TestA/../x", filepath.Join("TestA", "_..", "x")},
+	}
+	for _, tc := range cases {
+		if got := artifactPath(tc.name); got != tc.want {
+			t.Errorf("artifactPath(%q) = %q; want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// withArtifactsDir makes s use dir as its artifacts root.
+func withArtifactsDir(s *supervisor, dir string) {
+	s.artifactsOnce.Do(func() { s.artifactsDir = dir })
+}
+
+func TestArtifactDir(t *testing.T) {
+	t.Parallel()
+	m := New(makeTestDim(1, 2))
+	root := t.TempDir()
+	withArtifactsDir(m.sup, root)
+	runGroup(t, "run", func(t *testing.T) {
+		r := m.NewRunner(t)
+		r.Run("test", func(t *testing.T, s Scenario) Fixture {
+			return ArtifactDir(t)
+		}, func(t *testing.T, f Fixture) {
+			if got := ArtifactDir(t); got != f {
+				t.Errorf("got test artifact dir %q; want fixture's %q", got, f)
+			}
+			t.Run("sub", func(t *testing.T) {
+				want := filepath.Join(f.(string), "sub")
+				if got := ArtifactDir(t); got != want {
+					t.Errorf("got subtest artifact dir %q; want %q", got, want)
+				}
+			})
+		})
+	})
+	for _, s := range []string{"dim1val1", "dim1val2"} {
+		dir := filepath.Join(root, "TestArtifactDir", "group", "run", s, "test", "sub")
+		if _, err := os.Stat(dir); err != nil {
+			t.Error(err)
+		}
+	}
+	if got := len(m.sup.artifacts); got != 2 {
+		t.Errorf("got %d artifact records; want 2", got)
+	}
+	// All tests passed, so there is nothing to bundle.
+	if path, err := m.BundleArtifacts(); path != "" || err != nil {
+		t.Errorf("got bundle %q, error %v; want none", path, err)
+	}
+}
+
+func TestSupervisor_bundleArtifacts_removesTemp(t *testing.T) {
+	t.Parallel()
+	s := newSupervisor()
+	root, err := s.artifactsRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.artifactsTemp {
+		// -tm.artifacts or Opts.ArtifactsDir is set, so the root is not
+		// temporary.
+		t.Skip("artifacts directory configured")
+	}
+	if err := os.WriteFile(filepath.Join(root, "process.log"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := s.bundleArtifacts(); path != "" || err != nil {
+		t.Errorf("got bundle %q, error %v; want none", path, err)
+	}
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Errorf("got temporary artifacts directory %s still present (%v); want it removed", root, err)
+	}
+}
+
+func TestSupervisor_bundleArtifacts(t *testing.T) {
+	t.Parallel()
+	s := newSupervisor()
+	root := t.TempDir()
+	withArtifactsDir(s, root)
+	dir := filepath.Join(root, "TestA", "x", "one")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "log.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.artifacts["TestA/x/one"] = artifactRecord{
+		dir:      dir,
+		scenario: Scenario{{Dimension: "dim1", Name: "x"}},
+	}
+
+	path, err := s.bundleArtifacts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "artifacts.tar.gz"); path != want {
+		t.Errorf("got bundle path %q; want %q", path, want)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	contents := map[string]string{}
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[hdr.Name] = string(b)
+	}
+	wantNames := []string{"index.txt", "TestA/x/one/", "TestA/x/one/log.txt"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("got entries %q; want %q", names, wantNames)
+	}
+	if got, want := contents["index.txt"], "TestA/x/one\tx\tMISSING\n"; got != want {
+		t.Errorf("got index %q; want %q", got, want)
+	}
+	if got := contents["TestA/x/one/log.txt"]; !strings.Contains(got, "hello") {
+		t.Errorf("got log.txt %q; want hello", got)
+	}
+}
@@ -0,0 +1,148 @@
+package testmatrix
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Combiner enumerates combinations of a set of item names. Each returned
+// combination becomes a single value of a Dimension created by Combinations.
+// The names passed to a Combiner are always sorted.
+type Combiner func(names []string) [][]string
+
+// EmptyCombinationName is the value name given to the empty combination.
+const EmptyCombinationName = "none"
+
+var (
+	// AllSubsets enumerates every subset of the items, including the empty
+	// set, which is named "none".
+	AllSubsets Combiner = func(names []string) [][]string {
+		var res [][]string
+		for mask := 0; mask < 1<<uint(len(names)); mask++ {
+			c := []string{}
+			for i, n := range names {
+				if mask&(1<<uint(i)) != 0 {
+					c = append(c, n)
+				}
+			}
+			res = append(res, c)
+		}
+		return res
+	}
+
+	// Singletons enumerates each item on its own.
+	Singletons Combiner = func(names []string) [][]string {
+		res := make([][]string, len(names))
+		for i, n := range names {
+			res[i] = []string{n}
+		}
+		return res
+	}
+
+	// LeaveOneOut enumerates every combination of all items but one.
+	LeaveOneOut Combiner = func(names []string) [][]string {
+		res := make([][]string, len(names))
+		for i := range names {
+			c := make([]string, 0, len(names)-1)
+			c = append(c, names[:i]...)
+			res[i] = append(c, names[i+1:]...)
+		}
+		return res
+	}
+)
+
+// PermutationsUpTo returns a Combiner that enumerates every ordered
+// permutation of between 1 and k distinct items. Unlike the other Combiners,
+// order is significant, so "a+b" and "b+a" are different values. It panics if
+// k is not positive.
+func PermutationsUpTo(k int) Combiner {
+	if k < 1 {
+		panic(fmt.Sprintf("PermutationsUpTo k must be positive; got %d", k))
+	}
+	return func(names []string) [][]string {
+		var res [][]string
+		var permute func(prefix []string, used map[string]bool)
+		permute = func(prefix []string, used map[string]bool) {
+			if len(prefix) != 0 {
+				res = append(res, append([]string(nil), prefix...))
+			}
+			if len(prefix) == k {
+				return
+			}
+			for _, n := range names {
+				if used[n] {
+					continue
+				}
+				used[n] = true
+				permute(append(prefix, n), used)
+				used[n] = false
+			}
+		}
+		permute(nil, map[string]bool{})
+		return res
+	}
+}
+
+// Combinations returns a new Dimension whose values are combinations of items,
+// as enumerated by combine. Each value is named by joining the names of the
+// items it contains with "+", for example "memo+trace", and the value itself is
+// a slice of those items in the same order.
+//
+// If every item has the same dynamic type, the slice has that element type
+// (e.g. []Decorator), otherwise it is a []interface{}. If the empty
+// combination is generated, it is the Dimension's default value.
+func Combinations(name, desc string, items Values, combine Combiner) Dimension {
+	names := make([]string, 0, len(items))
+	for n := range items {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	elemType := commonType(items)
+	values := Values{}
+	for _, c := range combine(names) {
+		valueName := combinationName(c)
+		if _, ok := values[valueName]; ok {
+			panic(fmt.Sprintf("duplicate combination %q in dimension %q", valueName, name))
+		}
+		slice := reflect.MakeSlice(reflect.SliceOf(elemType), 0, len(c))
+		for _, n := range c {
+			v := reflect.Zero(elemType)
+			if items[n] != nil {
+				v = reflect.ValueOf(items[n])
+			}
+			slice = reflect.Append(slice, v)
+		}
+		values[valueName] = slice.Interface()
+	}
+	d := Dim(name, desc, values)
+	if _, ok := values[EmptyCombinationName]; ok {
+		d = d.WithDefault(EmptyCombinationName)
+	}
+	return d
+}
+
+func combinationName(names []string) string {
+	if len(names) == 0 {
+		return EmptyCombinationName
+	}
+	return strings.Join(names, "+")
+}
+
+// commonType returns the dynamic type shared by all values, or the empty
+// interface type if they differ or any is nil.
+func commonType(values Values) reflect.Type {
+	var t reflect.Type
+	for _, v := range values {
+		vt := reflect.TypeOf(v)
+		if vt == nil || (t != nil && vt != t) {
+			return reflect.TypeOf((*interface{})(nil)).Elem()
+		}
+		t = vt
+	}
+	if t == nil {
+		return reflect.TypeOf((*interface{})(nil)).Elem()
+	}
+	return t
+}
@@ -0,0 +1,85 @@
+package testmatrix
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCombinations(t *testing.T) {
+	t.Parallel()
+	items := Values{"a": 1, "b": 2, "c": 3}
+	cases := []struct {
+		name      string
+		combine   Combiner
+		wantNames []string
+	}{
+		{"AllSubsets", AllSubsets, []string{"a", "a+b", "a+b+c", "a+c", "b", "b+c", "c", "none"}},
+		{"Singletons", Singletons, []string{"a", "b", "c"}},
+		{"LeaveOneOut", LeaveOneOut, []string{"a+b", "a+c", "b+c"}},
+		{"PermutationsUpTo1", PermutationsUpTo(1), []string{"a", "b", "c"}},
+		{"PermutationsUpTo2", PermutationsUpTo(2), []string{
+			"a", "a+b", "a+c", "b", "b+a", "b+c", "c", "c+a", "c+b",
+		}},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			d := Combinations("dim", "", items, tc.combine)
+			var got []string
+			for n := range d.values {
+				got = append(got, n)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.wantNames) {
+				t.Errorf("got names %q; want %q", got, tc.wantNames)
+			}
+		})
+	}
+}
+
+func TestPermutationsUpTo_invalid(t *testing.T) {
+	t.Parallel()
+	for _, k := range []int{0, -1} {
+		func() {
+			want := fmt.Sprintf("PermutationsUpTo k must be positive; got %d", k)
+			defer func() {
+				if got := fmt.Sprint(recover()); got != want {
+					t.Errorf("got panic %q; want %q", got, want)
+				}
+			}()
+			PermutationsUpTo(k)
+		}()
+	}
+}
+
+func TestCombinations_values(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name      string
+		items     Values
+		valueName string
+		want      interface{}
+	}{
+		{"typed", Values{"a": 1, "b": 2}, "a+b", []int{1, 2}},
+		{"typed-empty", Values{"a": 1, "b": 2}, "none", []int{}},
+		{"mixed", Values{"a": 1, "b": "two"}, "a+b", []interface{}{1, "two"}},
+		{"nil", Values{"a": 1, "b": nil}, "a+b", []interface{}{1, nil}},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			d := Combinations("dim", "", tc.items, AllSubsets)
+			got, ok := d.values[tc.valueName]
+			if !ok {
+				t.Fatalf("missing value %q", tc.valueName)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v; want %#v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package testmatrix
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Contributor is implemented by dimension values which contribute part of a
+// fixture, for example a client, a binary path, or environment variables.
+//
+// Contribute is called once per test by Compose, in dimension order. It adds
+// its part to the fixture using b, and registers any cleanup it needs using
+// b.Cleanup. If it returns an error, the test fails.
+//
+// If a value implements Preparable, its prepared resource is available to
+// Contribute as b.Resource().
+type Contributor interface {
+	Contribute(b *FixtureBuilder) error
+}
+
+// FixtureBuilder is passed to each Contributor in turn to assemble a
+// ComposedFixture.
+type FixtureBuilder struct {
+	// T is the test the fixture is being built for.
+	T *testing.T
+	// Scenario is the scenario the fixture is being built for.
+	Scenario Scenario
+	// Binding is the binding of the Contributor currently being called.
+	Binding Binding
+	fixture *ComposedFixture
+}
+
+// Set sets the named part of the fixture, replacing any previous part with
+// the same name.
+func (b *FixtureBuilder) Set(name string, value interface{}) {
+	b.fixture.parts[name] = value
+}
+
+// Get returns the named part of the fixture, as set by an earlier
+// Contributor, and whether it was set.
+func (b *FixtureBuilder) Get(name string) (interface{}, bool) {
+	v, ok := b.fixture.parts[name]
+	return v, ok
+}
+
+// Resource returns the resource prepared for the binding of the Contributor
+// currently being called, if its value implements Preparable. See
+// PreparedResource.
+func (b *FixtureBuilder) Resource() interface{} {
+	b.T.Helper()
+	return PreparedResource(b.T, b.Binding.Dimension)
+}
+
+// Setenv sets an environment variable in the fixture's Env.
+func (b *FixtureBuilder) Setenv(key, value string) {
+	b.fixture.env[key] = value
+}
+
+// Cleanup registers f to be called when the fixture is torn down. Cleanup
+// funcs are called in the reverse order they were registered, so the last
+// dimension's contribution is torn down first.
+func (b *FixtureBuilder) Cleanup(f func()) {
+	b.fixture.Push(func(context.Context) error {
+		f()
+		return nil
+	})
+}
+
+// CleanupContext is like Cleanup, but f is passed a context carrying the
+// teardown deadline, and any error it returns fails the test.
+func (b *FixtureBuilder) CleanupContext(f func(context.Context) error) {
+	b.fixture.Push(f)
+}
+
+// ComposedFixture is a Fixture assembled from the contributions of each of a
+// scenario's values which implement Contributor. Its embedded CleanupStack
+// holds the cleanups registered by each Contributor.
+type ComposedFixture struct {
+	CleanupStack
+	// Scenario is the scenario this fixture was built for.
+	Scenario Scenario
+	// Sandbox is the fixture's sandbox, or nil if Sandboxed was not used.
+	Sandbox *Sandbox
+	parts   map[string]interface{}
+	env     map[string]string
+}
+
+// Compose is a FixtureFactory which builds a *ComposedFixture by calling
+// Contribute on each value in s that implements Contributor, in dimension
+// order. If any Contributor fails, cleanups registered so far are run and t
+// fails.
+func Compose(t *testing.T, s Scenario) Fixture {
+	t.Helper()
+	f, err := ComposeE(t, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// ComposeE is like Compose, but is a FixtureFactoryE, so returns the error
+// from any failed Contributor instead of failing t. Contributors can wrap
+// ErrUnsupported or ErrInfrastructure to classify their errors.
+//
+// Runner.Run and Runner.RunE use ComposeE if passed a nil fixture factory.
+func ComposeE(t *testing.T, s Scenario) (Fixture, error) {
+	f := &ComposedFixture{
+		Scenario: s,
+		Sandbox:  SandboxOf(t),
+		parts:    map[string]interface{}{},
+		env:      map[string]string{},
+	}
+	if f.Sandbox != nil {
+		for _, kv := range f.Sandbox.Env() {
+			k, v, _ := strings.Cut(kv, "=")
+			f.env[k] = v
+		}
+	}
+	b := &FixtureBuilder{T: t, Scenario: s, fixture: f}
+	for _, binding := range s {
+		c, ok := binding.Value.(Contributor)
+		if !ok {
+			continue
+		}
+		b.Binding = binding
+		if err := c.Contribute(b); err != nil {
+			ctx, cancel := teardownContext()
+			if cerr := f.TeardownContext(ctx); cerr != nil {
+				t.Errorf("cleaning up partial fixture: %s", cerr)
+			}
+			cancel()
+			return nil, fmt.Errorf("building fixture for %s=%s: %w", binding.Dimension, binding.Name, err)
+		}
+	}
+	return f, nil
+}
+
+// Get returns the named part of the fixture. It panics if no Contributor set
+// that part.
+func (f *ComposedFixture) Get(name string) interface{} {
+	v, ok := f.parts[name]
+	if !ok {
+		panic(fmt.Sprintf("fixture has no part %q", name))
+	}
+	return v
+}
+
+// Lookup returns the named part of the fixture, and whether it was set.
+func (f *ComposedFixture) Lookup(name string) (interface{}, bool) {
+	v, ok := f.parts[name]
+	return v, ok
+}
+
+// Env returns the environment variables set by Contributors, in the form
+// "key=value", sorted by key. If the fixture has a Sandbox, its environment
+// is included, unless overridden by a Contributor.
+func (f *ComposedFixture) Env() []string {
+	env := make([]string, 0, len(f.env))
+	for k, v := range f.env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
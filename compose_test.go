@@ -0,0 +1,82 @@
+package testmatrix
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// testContributor sets a part named after its dimension, and records its
+// cleanup in log.
+type testContributor struct {
+	mu  *sync.Mutex
+	log *[]string
+}
+
+func (c testContributor) Contribute(b *FixtureBuilder) error {
+	dim := b.Binding.Dimension
+	b.Set(dim, b.Binding.Name)
+	b.Setenv("TEST_"+dim, b.Binding.Name)
+	b.Cleanup(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		*c.log = append(*c.log, dim)
+	})
+	return nil
+}
+
+func TestCompose(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var log []string
+	c := testContributor{mu: &mu, log: &log}
+	m := New(
+		Dim("a", "", Values{"a1": c}),
+		Dim("b", "", Values{"b1": "not a contributor"}),
+		Dim("c", "", Values{"c1": c}),
+	)
+	var got *ComposedFixture
+	runGroup(t, "run", func(t *testing.T) {
+		m.NewRunner(t).Run("test", nil, func(t *testing.T, f Fixture) {
+			got = f.(*ComposedFixture)
+		})
+	})
+	if got == nil {
+		t.Fatalf("test did not run")
+	}
+	if a, c := got.Get("a"), got.Get("c"); a != "a1" || c != "c1" {
+		t.Errorf("got parts a=%v, c=%v; want a=a1, c=c1", a, c)
+	}
+	if _, ok := got.Lookup("b"); ok {
+		t.Errorf("got part b from non-contributor")
+	}
+	if want := []string{"TEST_a=a1", "TEST_c=c1"}; !reflect.DeepEqual(got.Env(), want) {
+		t.Errorf("got env %q; want %q", got.Env(), want)
+	}
+	if want := []string{"c", "a"}; !reflect.DeepEqual(log, want) {
+		t.Errorf("got cleanup order %q; want %q", log, want)
+	}
+}
+
+// preparedContributor contributes the resource prepared for it.
+type preparedContributor struct{ testPreparable }
+
+func (c *preparedContributor) Contribute(b *FixtureBuilder) error {
+	b.Set("tool", b.Resource())
+	return nil
+}
+
+func TestCompose_preparedResource(t *testing.T) {
+	t.Parallel()
+	c := &preparedContributor{testPreparable{name: "p"}}
+	m := New(Dim("tool", "", Values{"p": c}))
+	var got interface{}
+	runGroup(t, "run", func(t *testing.T) {
+		m.NewRunner(t).Run("test", nil, func(t *testing.T, f Fixture) {
+			got = f.(*ComposedFixture).Get("tool")
+		})
+	})
+	if got != "resource-p" {
+		t.Errorf("got part tool=%v; want resource-p", got)
+	}
+}
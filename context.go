@@ -0,0 +1,220 @@
+package testmatrix
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// ContextTest is like Test, but is also passed the test's context. See
+// Context.
+type ContextTest func(ctx context.Context, t *testing.T, fixture Fixture)
+
+// ContextFixtureFactory is like FixtureFactoryE, but is also passed the
+// test's context. See Context.
+type ContextFixtureFactory func(ctx context.Context, t *testing.T, s Scenario) (Fixture, error)
+
+// RunContext is like RunE, but takes a ContextFixtureFactory and a
+// ContextTest, which are passed the context of each test, so that tests
+// talking to external systems can be cancelled cleanly. See Context.
+//
+// If makeFixture is nil, ComposeE is used to assemble the fixture from the
+// scenario's values.
+func (pf *Runner) RunContext(name string, makeFixture ContextFixtureFactory, test ContextTest, options ...RunOption) {
+	var makeFixtureE FixtureFactoryE
+	if makeFixture != nil {
+		makeFixtureE = func(t *testing.T, c Scenario) (Fixture, error) {
+			return makeFixture(Context(t), t, c)
+		}
+	}
+	pf.RunE(name, makeFixtureE, func(t *testing.T, f Fixture) {
+		test(Context(t), t, f)
+	}, options...)
+}
+
+// Context returns the context of t, which must be a test started by
+// Runner.Run, Runner.RunE or Runner.RunContext, or a subtest of one. For any
+// other test it returns context.Background().
+//
+// The context is done once t has finished, when the run is interrupted, or
+// when any test fails if -test.failfast is set. If a scenario timeout is set
+// using -tm.scenario-timeout or Opts.ScenarioTimeout, then once t has been
+// released to run and holds its slots and resources, the context's deadline
+// is the scenario deadline. If that deadline is reached while t is running,
+// t fails with a dump of the stacks of its goroutines, which is also written
+// to goroutines.txt in its artifact directory. Fixtures built before t is
+// released, for example by Prefetch, are passed a context without the
+// deadline; see Opts.ScenarioTimeout.
+//
+// The goroutines of each test are attributed to it using the pprof labels
+// "testmatrix.test" and "testmatrix.scenario", which the context carries.
+func Context(t *testing.T) context.Context {
+	_, rt, err := lookupRunningTest(t.Name())
+	if err != nil {
+		return context.Background()
+	}
+	runningTestsMu.Lock()
+	defer runningTestsMu.Unlock()
+	if rt.ctx == nil {
+		return rt.sup.testsCtx
+	}
+	return rt.ctx
+}
+
+// Labels attributing goroutines to the test and scenario they belong to.
+const (
+	testLabel     = "testmatrix.test"
+	scenarioLabel = "testmatrix.scenario"
+)
+
+// startTestContext creates the context of t, which is running in scenario c,
+// and labels the goroutine of t so that it and any goroutines it starts are
+// attributed to t. The context has no deadline until startDeadline is
+// called. The returned func cancels the context, and must be called once t
+// has finished.
+func (s *supervisor) startTestContext(t *testing.T, c Scenario) func() {
+	ctx, cancel := context.WithCancel(s.testsCtx)
+	ctx = pprof.WithLabels(ctx, pprof.Labels(testLabel, t.Name(), scenarioLabel, c.String()))
+	pprof.SetGoroutineLabels(ctx)
+	setContext(t.Name(), ctx)
+	return cancel
+}
+
+// startDeadline starts the scenario clock of t, once it has been released to
+// run and holds its slots and resources. If a scenario timeout is set, it
+// gives the context of t the scenario deadline, and fails t if it is still
+// running then. The returned func must be called once t has finished.
+func startDeadline(t *testing.T, c Scenario) func() {
+	startClock(t.Name(), true)
+	deadline, ok := scenarioDeadline(t.Name())
+	if !ok {
+		return func() {}
+	}
+	ctx, cancel := context.WithDeadline(Context(t), deadline)
+	setContext(t.Name(), ctx)
+	go watchDeadline(ctx, t, c)
+	return cancel
+}
+
+// setContext sets the context of the test called name.
+func setContext(name string, ctx context.Context) {
+	runningTestsMu.Lock()
+	defer runningTestsMu.Unlock()
+	if rt, ok := runningTests[name]; ok {
+		rt.ctx = ctx
+	}
+}
+
+// labelGoroutine labels the calling goroutine with the labels of t, for
+// fixtures built on behalf of t in another goroutine. Prefetch workers reset
+// their labels once the build is done.
+func labelGoroutine(t *testing.T) {
+	pprof.SetGoroutineLabels(Context(t))
+}
+
+// watchDeadline fails t with a dump of its goroutines if ctx reaches its
+// deadline, which can only happen while t is running.
+func watchDeadline(ctx context.Context, t *testing.T, c Scenario) {
+	// Do not attribute this goroutine to t, so that it is not in the dump.
+	pprof.SetGoroutineLabels(context.Background())
+	<-ctx.Done()
+	if ctx.Err() != context.DeadlineExceeded {
+		return
+	}
+	name := t.Name()
+	dump := goroutineDump(name)
+	if dir, err := artifactDir(name); err == nil {
+		if err := os.WriteFile(filepath.Join(dir, "goroutines.txt"), dump, 0644); err != nil {
+			rtLog("ERROR: Writing goroutines of %s: %s", name, err)
+		}
+	}
+	whileRunning(name, func(t *testing.T) {
+		t.Errorf("scenario %s did not finish within %s; goroutines of this test:\n%s",
+			c, scenarioTimeout(), dump)
+	})
+}
+
+// goroutineDump returns the stacks of goroutines attributed to the test
+// called name, or to its subtests, in the format of the goroutine profile
+// with debug=1.
+func goroutineDump(name string) []byte {
+	var all bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&all, 1); err != nil {
+		return []byte(err.Error())
+	}
+	label := strconv.Quote(testLabel) + ":" + strconv.Quote(name)
+	var dump bytes.Buffer
+	for _, stack := range splitStacks(&all) {
+		lines := strings.SplitN(stack, "\n", 3)
+		if len(lines) > 1 && labelsContain(lines[1], label) {
+			dump.WriteString(stack + "\n")
+		}
+	}
+	return dump.Bytes()
+}
+
+// labelsContain returns true if the labels line of a goroutine profile
+// includes label, which is in the form "key":"value".
+func labelsContain(line, label string) bool {
+	if !strings.HasPrefix(line, "# labels: {") {
+		return false
+	}
+	labels := strings.TrimSuffix(strings.TrimPrefix(line, "# labels: {"), "}")
+	for _, l := range strings.Split(labels, ", ") {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// splitStacks splits a goroutine profile written with debug=1 into its stack
+// records, which are separated by blank lines, dropping the header.
+func splitStacks(profile *bytes.Buffer) []string {
+	var stacks []string
+	var stack strings.Builder
+	sc := bufio.NewScanner(profile)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "goroutine profile:"):
+		case line == "":
+			if stack.Len() != 0 {
+				stacks = append(stacks, stack.String())
+				stack.Reset()
+			}
+		default:
+			stack.WriteString(line + "\n")
+		}
+	}
+	if stack.Len() != 0 {
+		stacks = append(stacks, stack.String())
+	}
+	return stacks
+}
+
+// failFast returns true if -test.failfast is set.
+func failFast() bool {
+	f := flag.Lookup("test.failfast")
+	return f != nil && f.Value.String() == "true"
+}
+
+// testFailed cancels the contexts of all running tests if -test.failfast is
+// set, since the test called name has failed.
+func (s *supervisor) testFailed(name string) {
+	if !failFast() {
+		return
+	}
+	s.failFastOnce.Do(func() {
+		rtLog("%s failed: cancelling the contexts of running tests (-test.failfast)", name)
+		s.cancelTests()
+	})
+}
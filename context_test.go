@@ -0,0 +1,203 @@
+package testmatrix
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunner_RunContext(t *testing.T) {
+	t.Parallel()
+	m := New(makeTestDim(1, 2))
+	var contexts []context.Context
+	runGroup(t, "run", func(t *testing.T) {
+		r := m.NewRunner(t, WithParallelism(Serial))
+		r.RunContext("test", func(ctx context.Context, t *testing.T, c Scenario) (Fixture, error) {
+			if ctx != Context(t) {
+				t.Errorf("fixture factory got a context other than Context(t)")
+			}
+			return c, nil
+		}, func(ctx context.Context, t *testing.T, f Fixture) {
+			if ctx != Context(t) {
+				t.Errorf("test got a context other than Context(t)")
+			}
+			if err := ctx.Err(); err != nil {
+				t.Errorf("context done while test running: %s", err)
+			}
+			if got, _ := pprof.Label(ctx, testLabel); got != t.Name() {
+				t.Errorf("got test label %q; want %q", got, t.Name())
+			}
+			if got, _ := pprof.Label(ctx, scenarioLabel); got != f.(Scenario).String() {
+				t.Errorf("got scenario label %q; want %q", got, f.(Scenario).String())
+			}
+			contexts = append(contexts, ctx)
+		})
+	})
+	if len(contexts) != 2 {
+		t.Fatalf("got %d contexts; want 2", len(contexts))
+	}
+	for _, ctx := range contexts {
+		if ctx.Err() == nil {
+			t.Errorf("context not done after test finished")
+		}
+	}
+}
+
+func TestGoroutineDump(t *testing.T) {
+	t.Parallel()
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels(testLabel, "TestA/x"))
+	stop := make(chan struct{})
+	defer close(stop)
+	started := make(chan struct{})
+	go pprof.Do(ctx, pprof.Labels(), func(context.Context) {
+		close(started)
+		blockForDump(stop)
+	})
+	<-started
+	if got := string(goroutineDump("TestA/x")); !strings.Contains(got, "blockForDump") {
+		t.Errorf("got dump %q; want it to contain blockForDump", got)
+	}
+	if got := string(goroutineDump("TestA")); strings.Contains(got, "blockForDump") {
+		t.Errorf("got dump %q for another test; want it not to contain blockForDump", got)
+	}
+}
+
+func blockForDump(stop chan struct{}) {
+	<-stop
+}
+
+func TestLabelsContain(t *testing.T) {
+	t.Parallel()
+	line := `# labels: {"testmatrix.scenario":"a/b", "testmatrix.test":"TestA/a/b/x"}`
+	cases := []struct {
+		label string
+		want  bool
+	}{
+		{`"testmatrix.test":"TestA/a/b/x"`, true},
+		{`"testmatrix.test":"TestA/a/b"`, false},
+		{`"testmatrix.scenario":"a/b"`, true},
+	}
+	for _, tc := range cases {
+		if got := labelsContain(line, tc.label); got != tc.want {
+			t.Errorf("labelsContain(%q) = %t; want %t", tc.label, got, tc.want)
+		}
+	}
+}
+
+// TestScenarioTimeout_queued is not parallel, since it modifies the global
+// opts.
+func TestScenarioTimeout_queued(t *testing.T) {
+	original := opts
+	defer func() { opts = original }()
+	opts.ScenarioTimeout = 300 * time.Millisecond
+	cases := []struct {
+		name    string
+		matrix  func(Matrix) Matrix
+		options []RunOption
+	}{
+		{"maxparallel", func(m Matrix) Matrix { return m }, []RunOption{WithParallelism(MaxParallel(1))}},
+		{"resource", func(m Matrix) Matrix { return m.Resource("db", 1) }, []RunOption{Consumes("db", 1)}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := tc.matrix(New(makeTestDim(1, 4)))
+			var r *Runner
+			runGroup(t, "run", func(t *testing.T) {
+				r = m.NewRunner(t)
+				r.RunContext("test", nil, func(ctx context.Context, t *testing.T, f Fixture) {
+					select {
+					case <-ctx.Done():
+						t.Errorf("context done while test running: %s", ctx.Err())
+					case <-time.After(200 * time.Millisecond):
+					}
+				}, tc.options...)
+			})
+			// Queued behind each other, the tests take 800ms in total, but
+			// each is only given 300ms once it is released.
+			_, passed, _, _, _, _, _ := r.summary()
+			if len(passed) != 4 {
+				t.Errorf("got %d tests passed; want 4", len(passed))
+			}
+		})
+	}
+}
+
+func TestHelper_scenarioDeadline(t *testing.T) {
+	helperTest(t)
+	m := New(makeTestDim(1, 2))
+	r := m.NewRunner(t, WithParallelism(Parallel))
+	r.RunContext("test", nil, func(ctx context.Context, t *testing.T, f Fixture) {
+		blockPastDeadline(ctx)
+	})
+}
+
+// blockPastDeadline blocks until a little after ctx is done, so that its
+// test is still running when its goroutines are dumped.
+func blockPastDeadline(ctx context.Context) {
+	<-ctx.Done()
+	time.Sleep(200 * time.Millisecond)
+}
+
+func TestScenarioTimeout_expired(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	out := runHelperTest(t, "TestHelper_scenarioDeadline",
+		"-tm.scenario-timeout=100ms", "-tm.artifacts="+root, "-test.parallel=2")
+	for _, want := range []string{
+		"scenario dim1val1 did not finish within 100ms; goroutines of this test:",
+		"blockPastDeadline",
+		"--- FAIL: TestHelper_scenarioDeadline/dim1val1/test",
+		"--- FAIL: TestHelper_scenarioDeadline/dim1val2/test",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got output:\n%s\nwant it to contain %q", out, want)
+		}
+	}
+	name := "TestHelper_scenarioDeadline/dim1val1/test"
+	dump, err := os.ReadFile(filepath.Join(root, artifactPath(name), "goroutines.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both tests are blocked when the dump is taken, but only the stacks of
+	// dim1val1 are in its dump.
+	got := string(dump)
+	if want := `"testmatrix.test":"` + name + `"`; !strings.Contains(got, want) || !strings.Contains(got, "blockPastDeadline") {
+		t.Errorf("got goroutines.txt:\n%s\nwant it to contain the stacks of %s", got, name)
+	}
+	if strings.Contains(got, "dim1val2") {
+		t.Errorf("got goroutines.txt:\n%s\nwant no stacks of other tests", got)
+	}
+}
+
+func TestHelper_failFast(t *testing.T) {
+	helperTest(t)
+	m := New(makeTestDim(1, 2))
+	r := m.NewRunner(t, WithParallelism(Parallel))
+	r.RunContext("test", func(ctx context.Context, t *testing.T, c Scenario) (Fixture, error) {
+		return c, nil
+	}, func(ctx context.Context, t *testing.T, f Fixture) {
+		if f.(Scenario).Value("dim1") == "dim1val1" {
+			time.Sleep(50 * time.Millisecond)
+			t.Fatal("failing fast")
+		}
+		select {
+		case <-ctx.Done():
+			t.Log("context cancelled by another failure")
+		case <-time.After(5 * time.Second):
+		}
+	})
+}
+
+func TestContext_failFast(t *testing.T) {
+	t.Parallel()
+	out := runHelperTest(t, "TestHelper_failFast", "-test.failfast", "-test.parallel=2")
+	for _, want := range []string{"failing fast", "context cancelled by another failure"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got output:\n%s\nwant it to contain %q", out, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package testmatrix
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Diagnoser is a kind of Fixture that can write diagnostic information when a
+// test using it fails, such as the logs of services it started. Its output is
+// added to the test log, and saved in the test's artifact directory. Diagnose is
+// called after the test body, before the AfterEach hook and teardown, so
+// anything it reports still exists.
+type Diagnoser interface {
+	Diagnose(t *testing.T, w io.Writer)
+}
+
+// diagnose calls Diagnose on fix if it is a Diagnoser, and then the
+// OnFailure hook, if t failed. Their output is added to the test log.
+func (pf *Runner) diagnose(t *testing.T, c Scenario, fix Fixture) {
+	t.Helper()
+	if !t.Failed() {
+		return
+	}
+	if d, ok := fix.(Diagnoser); ok {
+		pf.writeDiagnostics(t, "fixture diagnostics", func(w io.Writer) { d.Diagnose(t, w) })
+	}
+	if opts.OnFailure != nil {
+		pf.writeDiagnostics(t, "OnFailure diagnostics", func(w io.Writer) { opts.OnFailure(t, c, fix, w) })
+	}
+}
+
+// writeDiagnostics logs the output of write under the heading title, and
+// appends it to diagnostics.log in the test's artifact directory.
+func (pf *Runner) writeDiagnostics(t *testing.T, title string, write func(io.Writer)) {
+	t.Helper()
+	out := collectDiagnostics(write)
+	if out == "" {
+		return
+	}
+	t.Logf("%s:\n%s", title, out)
+	dir, err := artifactDir(t.Name())
+	if err == nil {
+		err = appendFile(filepath.Join(dir, "diagnostics.log"), "== "+title+" ==\n"+out+"\n")
+	}
+	if err != nil {
+		t.Logf("saving %s: %s", title, err)
+	}
+}
+
+func appendFile(path, s string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// collectDiagnostics returns whatever write writes, without trailing
+// newlines. A panic in write is reported in the output rather than crashing
+// the run.
+func collectDiagnostics(write func(io.Writer)) string {
+	var buf bytes.Buffer
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Fprintf(&buf, "\npanic writing diagnostics: %v", r)
+			}
+		}()
+		write(&buf)
+	}()
+	return string(bytes.TrimRight(buf.Bytes(), "\n"))
+}
@@ -0,0 +1,98 @@
+package testmatrix
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCollectDiagnostics(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name  string
+		write func(io.Writer)
+		want  string
+	}{
+		{"empty", func(io.Writer) {}, ""},
+		{"lines", func(w io.Writer) { fmt.Fprintln(w, "one"); fmt.Fprintln(w, "two") }, "one\ntwo"},
+		{"panic", func(w io.Writer) { fmt.Fprint(w, "partial"); panic("boom") }, "partial\npanic writing diagnostics: boom"},
+	}
+	for _, tc := range cases {
+		if got := collectDiagnostics(tc.write); got != tc.want {
+			t.Errorf("%s: got %q; want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+type diagnosedFixture struct{ called bool }
+
+func (f *diagnosedFixture) Diagnose(t *testing.T, w io.Writer) { f.called = true }
+
+func TestRunner_diagnose_passed(t *testing.T) {
+	t.Parallel()
+	f := &diagnosedFixture{}
+	(&Runner{}).diagnose(t, Scenario{}, f)
+	if f.called {
+		t.Errorf("Diagnose called for a passing test")
+	}
+}
+
+// tornDownFixture reports in its diagnostics whether it has been torn down.
+type tornDownFixture struct{ tornDown bool }
+
+func (f *tornDownFixture) Diagnose(t *testing.T, w io.Writer) {
+	fmt.Fprintf(w, "fixture diagnosed, torn down: %t", f.tornDown)
+}
+
+func (f *tornDownFixture) TeardownContext(ctx context.Context) error {
+	f.tornDown = true
+	return nil
+}
+
+func TestHelper_diagnose(t *testing.T) {
+	helperTest(t)
+	opts.OnFailure = func(t *testing.T, c Scenario, fix Fixture, w io.Writer) {
+		fmt.Fprintf(w, "OnFailure %s, torn down: %t", c, fix.(*tornDownFixture).tornDown)
+	}
+	m := New(makeTestDim(1, 2))
+	r := m.NewRunner(t)
+	r.Run("test", func(*testing.T, Scenario) Fixture { return &tornDownFixture{} }, func(t *testing.T, fix Fixture) {
+		if strings.HasSuffix(t.Name(), "/dim1val1/test") {
+			t.Error("failed")
+		}
+	})
+}
+
+func TestRunner_diagnose_failed(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	out := runHelperTest(t, "TestHelper_diagnose", "-tm.artifacts="+dir)
+	for _, want := range []string{
+		"--- FAIL: TestHelper_diagnose/dim1val1/test",
+		"fixture diagnostics:",
+		"fixture diagnosed, torn down: false",
+		"OnFailure diagnostics:",
+		"OnFailure dim1val1, torn down: false",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got output:\n%s\nwant it to contain %q", out, want)
+		}
+	}
+
+	log, err := os.ReadFile(filepath.Join(dir, "TestHelper_diagnose", "dim1val1", "test", "diagnostics.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "== fixture diagnostics ==\nfixture diagnosed, torn down: false\n" +
+		"== OnFailure diagnostics ==\nOnFailure dim1val1, torn down: false\n"
+	if string(log) != want {
+		t.Errorf("got diagnostics.log:\n%s\nwant:\n%s", log, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "TestHelper_diagnose", "dim1val2", "test", "diagnostics.log")); !os.IsNotExist(err) {
+		t.Errorf("got diagnostics.log for the passing test (%v); want none", err)
+	}
+}
@@ -1,5 +1,7 @@
 package testmatrix
 
+import "fmt"
+
 // Dimension represents a dimension in the test matrix.
 type Dimension struct {
 	// name is the name of this dimension, used to look up values in calculated
@@ -10,6 +12,12 @@ type Dimension struct {
 	// values is a map of named possible values for this Dimension.
 	// The name used here forms part of the sub-test path.
 	values Values
+	// def is the name of the default value, used as the baseline for the
+	// BaseChoice strategy.
+	def string
+	// sticky is true if tests sharing a value of this dimension should be
+	// run together. See Sticky.
+	sticky bool
 }
 
 // Dim returns a new Dimension.
@@ -20,3 +28,75 @@ func Dim(name, desc string, values Values) Dimension {
 		values: values,
 	}
 }
+
+// WithDefault returns a copy of d with its default value set to the named
+// value. The default is used to form the baseline scenario when using the
+// BaseChoice strategy. If no default is set, the first value name in sort
+// order is used.
+func (d Dimension) WithDefault(valueName string) Dimension {
+	d.def = valueName
+	return d
+}
+
+// Sticky returns a copy of d marked as sticky. Switching between values of a
+// sticky dimension is assumed to be expensive, e.g. restarting a daemon at a
+// different version. So within each call to Runner.Run, all scenarios sharing
+// the same sticky values run together, before any scenario with the next
+// sticky values starts. Combined with Preparable values, each expensive value
+// is then set up once and torn down as soon as its tests have finished.
+//
+// Sticky values come first in sub-test paths, regardless of dimension order,
+// so that each group of scenarios runs as a single sub-test.
+func (d Dimension) Sticky() Dimension {
+	d.sticky = true
+	return d
+}
+
+// IsSticky returns true if d was marked sticky using Sticky.
+func (d Dimension) IsSticky() bool {
+	return d.sticky
+}
+
+// Name returns the name of this Dimension.
+func (d Dimension) Name() string {
+	return d.name
+}
+
+// Desc returns the description of this Dimension.
+func (d Dimension) Desc() string {
+	return d.desc
+}
+
+// Values returns a copy of the possible values of this Dimension.
+func (d Dimension) Values() Values {
+	values := make(Values, len(d.values))
+	for n, v := range d.values {
+		values[n] = v
+	}
+	return values
+}
+
+// ValueNames returns the names of this Dimension's values in sort order.
+func (d Dimension) ValueNames() []string {
+	return sortedValueNames(d.values)
+}
+
+// Default returns the name of the default value of this Dimension. This is
+// the value set using WithDefault if it is still one of the Dimension's values,
+// otherwise the first value name in sort order.
+func (d Dimension) Default() string {
+	if _, ok := d.values[d.def]; ok {
+		return d.def
+	}
+	return d.ValueNames()[0]
+}
+
+// Binding returns the Binding of this Dimension to the named value.
+// It panics if there is no such value.
+func (d Dimension) Binding(valueName string) Binding {
+	v, ok := d.values[valueName]
+	if !ok {
+		panic(fmt.Sprintf("dimension %q has no value %q", d.name, valueName))
+	}
+	return Binding{Dimension: d.name, Name: valueName, Value: v}
+}
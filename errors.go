@@ -0,0 +1,83 @@
+package testmatrix
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var (
+	// ErrUnsupported is returned (possibly wrapped) by a FixtureFactoryE when
+	// the scenario is not supported, e.g. a combination of versions that are
+	// known not to work together. Tests in that scenario are skipped.
+	ErrUnsupported = errors.New("scenario not supported")
+	// ErrInfrastructure is returned (possibly wrapped) by a FixtureFactoryE
+	// when the test environment could not be set up, e.g. a service failed
+	// to start. Tests in that scenario fail, but are reported separately from
+	// genuine test failures in the summary.
+	ErrInfrastructure = errors.New("infrastructure error")
+)
+
+// FixtureFactoryE is like FixtureFactory, but can return an error instead of
+// failing the test. Errors wrapping ErrUnsupported skip the test, and errors
+// wrapping ErrInfrastructure are reported as infrastructure errors. Any other
+// error fails the test.
+type FixtureFactoryE func(*testing.T, Scenario) (Fixture, error)
+
+// fixtureFactoryE adapts a FixtureFactory to a FixtureFactoryE.
+func fixtureFactoryE(f FixtureFactory) FixtureFactoryE {
+	return func(t *testing.T, s Scenario) (Fixture, error) {
+		return f(t, s), nil
+	}
+}
+
+// callFactory calls f, converting any panic into an infrastructure error
+// naming the scenario.
+func callFactory(t *testing.T, c Scenario, f FixtureFactoryE) (fix Fixture, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: panic creating fixture for scenario %s: %v\n%s",
+				ErrInfrastructure, c, r, debug.Stack())
+		}
+	}()
+	return f(t, c)
+}
+
+// handleFixtureError skips or fails t according to the kind of err.
+func (pf *Runner) handleFixtureError(t *testing.T, c Scenario, err error) {
+	t.Helper()
+	switch {
+	case errors.Is(err, ErrUnsupported):
+		t.Skipf("scenario %s: %s", c, err)
+	case errors.Is(err, ErrInfrastructure):
+		pf.testNamesInfraMu.Lock()
+		pf.testNamesInfra[t.Name()] = strings.SplitN(err.Error(), "\n", 2)[0]
+		pf.testNamesInfraMu.Unlock()
+		t.Fatalf("scenario %s: %s", c, err)
+	default:
+		t.Fatalf("creating fixture for scenario %s: %s", c, err)
+	}
+}
+
+func (pf *Runner) wasInfraError(name string) bool {
+	pf.testNamesInfraMu.Lock()
+	defer pf.testNamesInfraMu.Unlock()
+	_, ok := pf.testNamesInfra[name]
+	return ok
+}
+
+// infraSlice returns a description of each test which failed with an
+// infrastructure error, sorted.
+func (pf *Runner) infraSlice() []string {
+	pf.testNamesInfraMu.Lock()
+	defer pf.testNamesInfraMu.Unlock()
+	var s []string
+	for name, err := range pf.testNamesInfra {
+		s = append(s, name+": "+err)
+	}
+	sort.Strings(s)
+	return s
+}
@@ -0,0 +1,42 @@
+package testmatrix
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCallFactory_panic(t *testing.T) {
+	t.Parallel()
+	c := Scenario{{Dimension: "dim1", Name: "dim1val1"}}
+	_, err := callFactory(t, c, func(*testing.T, Scenario) (Fixture, error) {
+		panic("boom")
+	})
+	if !errors.Is(err, ErrInfrastructure) {
+		t.Errorf("got error %v; want it to wrap ErrInfrastructure", err)
+	}
+	if want := "panic creating fixture for scenario dim1val1: boom"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v; want it to contain %q", err, want)
+	}
+}
+
+func TestRunner_RunE_unsupported(t *testing.T) {
+	t.Parallel()
+	m := New(makeTestDim(1, 2))
+	var r *Runner
+	runGroup(t, "run", func(t *testing.T) {
+		r = m.NewRunner(t)
+		r.RunE("test", func(t *testing.T, s Scenario) (Fixture, error) {
+			if s.Value("dim1") == "dim1val2" {
+				return nil, fmt.Errorf("dim1val2 is too new: %w", ErrUnsupported)
+			}
+			return nil, nil
+		}, func(*testing.T, Fixture) {})
+	})
+	_, passed, skipped, failed, infra, _, _ := r.summary()
+	if len(passed) != 1 || len(skipped) != 1 || len(failed) != 0 || len(infra) != 0 {
+		t.Errorf("got passed %q, skipped %q, failed %q, infra %q; want 1 passed and 1 skipped",
+			passed, skipped, failed, infra)
+	}
+}
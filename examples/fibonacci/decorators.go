@@ -12,3 +12,20 @@ var Memoize Decorator = func(p Provider) Provider {
 var NoDecorator Decorator = func(p Provider) Provider {
 	return p
 }
+
+// Trace decorates p so that it counts how many times it has been called.
+var Trace Decorator = func(p Provider) Provider {
+	return &Traced{provider: p}
+}
+
+// Traced counts calls to its Provider.
+type Traced struct {
+	Calls    int
+	provider Provider
+}
+
+// Fib returns the nth Fibonacci number, and counts the call.
+func (f *Traced) Fib(n int) int {
+	f.Calls++
+	return f.provider.Fib(n)
+}
@@ -5,6 +5,13 @@
 // purposes.
 package fibonacci
 
+import "github.com/samsalisbury/testmatrix"
+
+func init() {
+	testmatrix.Register[Provider]("recur", &Recursive{})
+	testmatrix.Register[Provider]("iter", &Iterative{})
+}
+
 // Provider is a provider of a Fibonacci function.
 type Provider interface {
 	Fib(int) int
@@ -7,23 +7,28 @@ import (
 	"github.com/samsalisbury/testmatrix"
 )
 
-// matrix is our matrix definition.
-var matrix = testmatrix.New(
-	testmatrix.Dim("fib", "fibbonaci func", testmatrix.Values{
-		"recur": &Recursive{},
-		"iter":  &Iterative{},
-	}),
-	testmatrix.Dim("decorator", "decorator to use", testmatrix.Values{
-		"none": NoDecorator,
-		"memo": Memoize,
-	}),
-)
+// matrix is our matrix definition, set in TestMain.
+var matrix testmatrix.Matrix
+
+// makeMatrix returns our matrix definition. Every registered Provider is
+// included automatically, so it must be called after init, not used to
+// initialise a package-level variable.
+func makeMatrix() testmatrix.Matrix {
+	return testmatrix.New(
+		testmatrix.Dim("fib", "fibbonaci func", testmatrix.Registered[Provider]()),
+		testmatrix.Combinations("decorator", "decorators to apply", testmatrix.Values{
+			"memo":  Memoize,
+			"trace": Trace,
+		}, testmatrix.AllSubsets),
+	)
+}
 
 // TestMain tells us to run tests as defined in the matrix.
 // Note: if you need more control over TestMain, you can manually call
 // matrix.Init and matrix.PrintSummary instead of matrix.Run.
 // See the implementation of matrix.Run for details on how to do this.
 func TestMain(m *testing.M) {
+	matrix = makeMatrix()
 	os.Exit(matrix.Run(m))
 }
 
@@ -40,9 +45,11 @@ type fixtureFunc func(*testing.T, testmatrix.Scenario) *fixture
 // and scenario.
 func makeFixture(t *testing.T, s testmatrix.Scenario) *fixture {
 	provider := s.Value("fib").(Provider)
-	decorated := s.Value("decorator").(Decorator)
+	for _, decorate := range s.Value("decorator").([]Decorator) {
+		provider = decorate(provider)
+	}
 	return &fixture{
-		Provider: decorated(provider),
+		Provider: provider,
 	}
 }
 
@@ -5,5 +5,25 @@ import (
 )
 
 var (
-	printInfo = flag.Bool("tm.info", false, "print matrix info and exit")
+	printInfo    = flag.Bool("tm.info", false, "print matrix info and exit")
+	strategyFlag = flag.String("tm.strategy", "", "name of the scenario generation strategy, e.g. full or base-choice")
+
+	teardownTimeoutFlag = flag.Duration("tm.teardown-timeout", 0, "deadline for each fixture teardown (default 10s)")
+	scenarioTimeoutFlag = flag.Duration("tm.scenario-timeout", 0, "hard deadline for each test, from when it is released to run; tests still running at it fail with a dump of their goroutines")
+	teardownWaitFlag    = flag.Duration("tm.teardown-wait", 0, "deadline for outstanding teardowns to finish after all tests (default 10s)")
+
+	keepFailedFlag  = flag.Bool("tm.keep-failed", false, "do not tear down fixtures or Preparable values of failed tests; print their description and cleanup command instead")
+	pauseOnFailFlag = flag.Bool("tm.pause-on-fail", false, "when a test fails, wait for enter to be pressed before tearing down its fixture")
+
+	parallelFlag Parallelism
+
+	prefetchFlag = flag.Int("tm.prefetch", 0, "number of fixtures to build ahead of their tests in the background; overrides the Prefetch option and Opts.Prefetch")
+
+	artifactsFlag = flag.String("tm.artifacts", "", "directory to write per-test artifact directories to (default a new temporary directory)")
+	bundleFlag    = flag.String("tm.bundle", "", "path of the tar.gz bundle of artifacts of failed tests (default artifacts.tar.gz in the artifacts directory)")
+	bundleAllFlag = flag.Bool("tm.bundle-all", false, "bundle the artifacts of all tests, not just failed ones")
 )
+
+func init() {
+	flag.Var(&parallelFlag, "tm.parallel", "run tests serial, parallel, or in parallel with at most N at once; overrides WithParallelism")
+}
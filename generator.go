@@ -0,0 +1,113 @@
+package testmatrix
+
+// ScenarioGenerator generates the scenarios to run from the dimensions of a
+// Matrix, which are passed in order. Each Scenario returned should contain
+// exactly one Binding for each dimension, in the same order as the
+// dimensions, since that order determines sub-test names.
+//
+// Implement this to plug in your own reduction strategies, and select it
+// using Matrix.WithGenerator, or register it using RegisterStrategy to make it
+// available by name via the -tm.strategy flag.
+type ScenarioGenerator interface {
+	Scenarios(dims []Dimension) []Scenario
+}
+
+// ScenarioGeneratorFunc is a func that implements ScenarioGenerator.
+type ScenarioGeneratorFunc func(dims []Dimension) []Scenario
+
+// Scenarios calls f(dims).
+func (f ScenarioGeneratorFunc) Scenarios(dims []Dimension) []Scenario {
+	return f(dims)
+}
+
+// BaselineGenerator is a ScenarioGenerator which generates scenarios relative
+// to a baseline scenario. The baseline is named in the summary.
+type BaselineGenerator interface {
+	ScenarioGenerator
+	Baseline(dims []Dimension) Scenario
+}
+
+// FullProductGenerator generates every combination of a single value from
+// each dimension. This is the default ScenarioGenerator.
+type FullProductGenerator struct{}
+
+// Scenarios returns the full product of all dimensions' values.
+func (FullProductGenerator) Scenarios(dims []Dimension) []Scenario {
+	if len(dims) == 0 {
+		return nil
+	}
+	combos := [][]Scenario{}
+	for _, d := range dims {
+		c := []Scenario{}
+		for _, name := range d.ValueNames() {
+			c = append(c, Scenario{d.Binding(name)})
+		}
+		combos = append(combos, c)
+	}
+	return product(combos...)
+}
+
+// BaseChoiceGenerator generates a baseline scenario made up of each
+// dimension's default value, plus one scenario for each non-default value,
+// varying only that value's dimension from the baseline. This gives a cheap
+// smoke matrix which isolates the effect of each value.
+type BaseChoiceGenerator struct{}
+
+// Baseline returns the scenario made up of each dimension's default value.
+func (BaseChoiceGenerator) Baseline(dims []Dimension) Scenario {
+	s := make(Scenario, len(dims))
+	for i, d := range dims {
+		s[i] = d.Binding(d.Default())
+	}
+	return s
+}
+
+// Scenarios returns the baseline followed by each single-dimension variation
+// of it.
+func (g BaseChoiceGenerator) Scenarios(dims []Dimension) []Scenario {
+	base := g.Baseline(dims)
+	scenarios := []Scenario{base}
+	for i, d := range dims {
+		for _, name := range d.ValueNames() {
+			if name == base[i].Name {
+				continue
+			}
+			s := append(Scenario(nil), base...)
+			s[i] = d.Binding(name)
+			scenarios = append(scenarios, s)
+		}
+	}
+	return scenarios
+}
+
+func product(slices ...[]Scenario) []Scenario {
+	res := slices[0]
+	for _, s := range slices[1:] {
+		res = mult(res, s)
+	}
+	return res
+}
+
+func mult(a, b []Scenario) []Scenario {
+	res := make([][]Scenario, len(a)*len(b))
+	n := 0
+	for _, aa := range a {
+		for _, bb := range b {
+			res[n] = []Scenario{aa, bb}
+			n++
+		}
+	}
+	slice := make([]Scenario, len(res))
+	for i, r := range res {
+		slice[i] = concat(r)
+	}
+	return slice
+}
+
+func concat(scenarios []Scenario) Scenario {
+	res := append(Scenario(nil), scenarios[0]...)
+	for _, c := range scenarios[1:] {
+		res = append(res, c...)
+	}
+	return res
+}
@@ -0,0 +1,103 @@
+package testmatrix
+
+import (
+	"sync"
+	"testing"
+)
+
+// Hook phases, used to attribute failures in the summary.
+const (
+	phaseOnScenarioStart = "OnScenarioStart"
+	phaseOnScenarioEnd   = "OnScenarioEnd"
+	phaseBeforeEach      = "BeforeEach"
+	phaseAfterEach       = "AfterEach"
+)
+
+// runHook calls f, which runs the named hook phase for t. If t was not failed
+// before f was called but is afterwards, the failure is attributed to that
+// phase in the summary.
+func (pf *Runner) runHook(t *testing.T, phase string, f func()) {
+	wasFailed := t.Failed()
+	defer func() {
+		if !wasFailed && t.Failed() {
+			pf.recordFailedIn(t.Name(), phase)
+		}
+	}()
+	f()
+}
+
+func (pf *Runner) recordFailedIn(name, phase string) {
+	pf.testNamesFailedInMu.Lock()
+	defer pf.testNamesFailedInMu.Unlock()
+	if _, ok := pf.testNamesFailedIn[name]; !ok {
+		pf.testNamesFailedIn[name] = phase
+	}
+}
+
+// scenarioState tracks the tests currently running in a single scenario,
+// across all top-level tests, so that the scenario hooks fire once when the
+// first test starts and once after the last test ends.
+type scenarioState struct {
+	key string
+	// refs is the number of tests currently running in this scenario.
+	// It is guarded by supervisor.scenariosMu.
+	refs    int
+	once    sync.Once
+	started bool
+}
+
+// retainScenario returns the scenarioState for c, with its reference count
+// incremented.
+func (s *supervisor) retainScenario(c Scenario) *scenarioState {
+	s.scenariosMu.Lock()
+	defer s.scenariosMu.Unlock()
+	key := c.String()
+	ss, ok := s.scenarios[key]
+	if !ok {
+		ss = &scenarioState{key: key}
+		s.scenarios[key] = ss
+	}
+	ss.refs++
+	return ss
+}
+
+// releaseScenario decrements the reference count of ss, and returns true if
+// the caller was the last test running in a successfully started scenario.
+func (s *supervisor) releaseScenario(ss *scenarioState) bool {
+	s.scenariosMu.Lock()
+	defer s.scenariosMu.Unlock()
+	ss.refs--
+	if ss.refs != 0 {
+		return false
+	}
+	if s.scenarios[ss.key] == ss {
+		delete(s.scenarios, ss.key)
+	}
+	return ss.started
+}
+
+// startScenario calls the OnScenarioStart hook if t is the first test to
+// start in scenario c. If the hook failed in another test, t fails too.
+func (pf *Runner) startScenario(t *testing.T, c Scenario, ss *scenarioState) {
+	t.Helper()
+	ss.once.Do(func() {
+		wasFailed := t.Failed()
+		if opts.OnScenarioStart != nil {
+			pf.runHook(t, phaseOnScenarioStart, func() { opts.OnScenarioStart(t, c) })
+		}
+		ss.started = wasFailed || !t.Failed()
+	})
+	if !ss.started {
+		pf.runHook(t, phaseOnScenarioStart, func() {
+			t.Fatalf("OnScenarioStart hook failed for scenario %s", c)
+		})
+	}
+}
+
+// endScenario calls the OnScenarioEnd hook if t is the last test to end in
+// scenario c.
+func (pf *Runner) endScenario(t *testing.T, c Scenario, ss *scenarioState) {
+	if pf.parent.releaseScenario(ss) && opts.OnScenarioEnd != nil {
+		pf.runHook(t, phaseOnScenarioEnd, func() { opts.OnScenarioEnd(t, c) })
+	}
+}
@@ -0,0 +1,50 @@
+package testmatrix
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// TestOpts_hooks is not parallel, since it modifies the global opts.
+func TestOpts_hooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	original := opts
+	defer func() { opts = original }()
+	opts.OnScenarioStart = func(t *testing.T, s Scenario) { record("start " + s.String()) }
+	opts.OnScenarioEnd = func(t *testing.T, s Scenario) { record("end " + s.String()) }
+	opts.BeforeEach = func(t *testing.T, s Scenario, f Fixture) { record("before " + s.String()) }
+	opts.AfterEach = func(t *testing.T, s Scenario, f Fixture) { record("after " + s.String()) }
+
+	m := New(makeTestDim(1, 2))
+	runGroup(t, "run", func(t *testing.T) {
+		r := m.NewRunner(t)
+		for _, name := range []string{"one", "two"} {
+			r.Run(name, func(*testing.T, Scenario) Fixture { return nil }, func(*testing.T, Fixture) {})
+		}
+	})
+
+	for _, s := range []string{"dim1val1", "dim1val2"} {
+		var got []string
+		for _, e := range events {
+			if e[len(e)-len(s):] == s {
+				got = append(got, e[:len(e)-len(s)-1])
+			}
+		}
+		if len(got) != 6 || got[0] != "start" || got[5] != "end" {
+			t.Errorf("%s: got events %q; want start first and end last", s, got)
+		}
+		sort.Strings(got)
+		want := []string{"after", "after", "before", "before", "end", "start"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got events %q; want %q", s, got, want)
+		}
+	}
+}
@@ -12,13 +12,65 @@ package testmatrix
 
 import (
 	"flag"
+	"io"
+	"testing"
+	"time"
 )
 
 var opts = DefaultOpts()
 
 // Opts are global options.
 type Opts struct {
-	BeforeAll     func()
+	// BeforeAll is called once before any tests are run.
+	BeforeAll func()
+	// AfterAll is called once after all tests have run.
+	AfterAll func()
+	// BeforeEach is called before each test, after its fixture is created.
+	BeforeEach func(*testing.T, Scenario, Fixture)
+	// AfterEach is called after each test, before its fixture is torn down.
+	// It is called even if the test failed.
+	AfterEach func(*testing.T, Scenario, Fixture)
+	// OnScenarioStart is called when the first test in a scenario starts,
+	// before its fixture is created, and is passed that test. If it fails,
+	// all tests in the scenario fail.
+	OnScenarioStart func(*testing.T, Scenario)
+	// OnScenarioEnd is called after the last test in a scenario has ended
+	// and its fixture has been torn down, and is passed that test. For
+	// Serial tests, it is called once the test which ran them has finished,
+	// and is passed that test.
+	OnScenarioEnd func(*testing.T, Scenario)
+	// OnFailure is called after each failed test, before AfterEach and
+	// before its fixture is torn down. Anything it writes to w is added to
+	// the test log. See also Diagnoser.
+	OnFailure func(*testing.T, Scenario, Fixture, io.Writer)
+	// ScenarioTimeout is the time allowed for each test, measured from when
+	// it is released to run and holds its parallelism slots and resources,
+	// so time spent queued does not count. It includes creating its fixture,
+	// unless the fixture was built before the test was released, in which
+	// case the build is allowed ScenarioTimeout from when it starts. Tests
+	// still running at the deadline fail, and WaitReady waits until it. It
+	// is overridden by -tm.scenario-timeout. See Context.
+	ScenarioTimeout time.Duration
+	// TeardownTimeout is the deadline given to each fixture teardown.
+	// It defaults to 10 seconds, and is overridden by -tm.teardown-timeout.
+	TeardownTimeout time.Duration
+	// TeardownWaitTimeout is how long Run waits for teardowns still in
+	// progress after all tests have finished. Teardowns still running after
+	// this are listed in the summary, and cause a non-zero exit code.
+	// It defaults to 10 seconds, and is overridden by -tm.teardown-wait.
+	TeardownWaitTimeout time.Duration
+	// Prefetch is the number of fixtures to build ahead of their tests in
+	// the background, for each call to Runner.RunE or Runner.RunContext. It
+	// is overridden by the Prefetch RunOption, and by -tm.prefetch. See
+	// Prefetch.
+	Prefetch int
+	// ArtifactsDir is the directory artifact directories are created in.
+	// It defaults to a new temporary directory, and is overridden by
+	// -tm.artifacts. See ArtifactDir.
+	ArtifactsDir string
+	// BundleAll makes Run bundle the artifacts of all tests, not just those
+	// which failed. It can also be set using -tm.bundle-all.
+	BundleAll     bool
 	PrintInfoOnly bool
 }
 
@@ -36,6 +88,14 @@ func DefaultOpts() Opts {
 // Run wraps all initialisation logic, runs the tests, and returns the
 // appropriate exit code. This should only be called once, in TestMain.
 //
+// After all tests have finished, the artifact directories of failed tests are
+// bundled; see BundleArtifacts.
+//
+// While tests are running, Run handles SIGINT and SIGTERM by not starting
+// any more tests, cancelling Runner.Context, and printing a partial summary
+// once running tests and teardowns have finished. A second signal forces an
+// immediate exit.
+//
 // You should pass the *testing.M from TestMain as the first parameter.
 // We depend in the interface M for testing purposes.
 func (m *Matrix) Run(testingM M, config ...func(*Opts)) (exitCode int) {
@@ -43,7 +103,22 @@ func (m *Matrix) Run(testingM M, config ...func(*Opts)) (exitCode int) {
 		return 0
 	}
 	defer m.sup.PrintSummary()
-	return testingM.Run()
+	stop := m.sup.trapSignals()
+	defer stop()
+	exitCode = testingM.Run()
+	if !m.WaitForTeardowns() && exitCode == 0 {
+		exitCode = 1
+	}
+	if _, err := m.BundleArtifacts(); err != nil {
+		rtLog("ERROR: Bundling artifacts: %s", err)
+	}
+	if m.sup.interrupted() && exitCode == 0 {
+		exitCode = 1
+	}
+	if opts.AfterAll != nil {
+		opts.AfterAll()
+	}
+	return exitCode
 }
 
 // Init ensures flags are parsed, and makes decision on whether to actually run
@@ -58,6 +133,8 @@ func (m *Matrix) Init(config ...func(*Opts)) Opts {
 	for _, c := range config {
 		c(&opts)
 	}
+	// Fail early on an unknown -tm.strategy, rather than in every test.
+	m.effectiveGenerator()
 	if *printInfo {
 		m.PrintDimensions()
 		opts.PrintInfoOnly = true
@@ -0,0 +1,90 @@
+package testmatrix
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+// trapSignals handles SIGINT and SIGTERM until the returned func is called.
+// The first signal interrupts the run: no new tests are started, and the
+// context returned by Runner.Context is cancelled so running tests can stop
+// early. Running tests and their teardowns are left to finish so a partial
+// summary can be printed. A second signal forces an immediate exit.
+func (s *supervisor) trapSignals() (stop func()) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			rtLog("Received %s: not starting any more tests; waiting for running tests and teardowns to finish. Send again to force exit.", sig)
+			s.interrupt()
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-sigs:
+			rtLog("Received %s again: forcing exit.", sig)
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// interrupt marks the run as interrupted, and cancels the run context.
+func (s *supervisor) interrupt() {
+	atomic.StoreInt32(&s.interruptedFlag, 1)
+	s.cancel()
+}
+
+// interrupted returns true if the run has been interrupted.
+func (s *supervisor) interrupted() bool {
+	return atomic.LoadInt32(&s.interruptedFlag) == 1
+}
+
+// Context returns a context which is cancelled when the run is interrupted.
+// Tests which talk to external systems should use it, or the context of each
+// test returned by the package-level Context, so that they can be cancelled
+// cleanly.
+func (pf *Runner) Context() context.Context {
+	return pf.parent.ctx
+}
+
+// skipIfInterrupted skips t, recording it as interrupted, if the run has been
+// interrupted.
+func (pf *Runner) skipIfInterrupted(t *testing.T) {
+	t.Helper()
+	if !pf.parent.interrupted() {
+		return
+	}
+	pf.testNamesInterruptedMu.Lock()
+	pf.testNamesInterrupted[t.Name()] = struct{}{}
+	pf.testNamesInterruptedMu.Unlock()
+	t.Skip("run interrupted")
+}
+
+func (pf *Runner) wasInterrupted(name string) bool {
+	pf.testNamesInterruptedMu.Lock()
+	defer pf.testNamesInterruptedMu.Unlock()
+	_, ok := pf.testNamesInterrupted[name]
+	return ok
+}
+
+// interruptedSlice returns the names of tests which were not run because the
+// run was interrupted, sorted.
+func (pf *Runner) interruptedSlice() []string {
+	pf.testNamesInterruptedMu.Lock()
+	defer pf.testNamesInterruptedMu.Unlock()
+	s := testNamesSlice(pf.testNamesInterrupted)
+	sort.Strings(s)
+	return s
+}
@@ -0,0 +1,37 @@
+package testmatrix
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRunner_Run_interrupted(t *testing.T) {
+	t.Parallel()
+	m := New(makeTestDim(1, 2))
+	m.sup.interrupt()
+	var r *Runner
+	var ran bool
+	runGroup(t, "run", func(t *testing.T) {
+		r = m.NewRunner(t)
+		r.Run("test", func(*testing.T, Scenario) Fixture {
+			ran = true
+			return nil
+		}, func(*testing.T, Fixture) {
+			ran = true
+		})
+	})
+	if ran {
+		t.Errorf("fixture or test ran after interruption")
+	}
+	if err := r.Context().Err(); err != context.Canceled {
+		t.Errorf("got context error %v; want %v", err, context.Canceled)
+	}
+	total, passed, skipped, failed, infra, interrupted, missing := r.summary()
+	if len(total) != 2 || len(interrupted) != 2 {
+		t.Errorf("got %d interrupted of %d; want 2 of 2", len(interrupted), len(total))
+	}
+	if len(passed)+len(skipped)+len(failed)+len(infra)+len(missing) != 0 {
+		t.Errorf("got passed %q, skipped %q, failed %q, infra %q, missing %q; want none",
+			passed, skipped, failed, infra, missing)
+	}
+}
@@ -0,0 +1,148 @@
+package testmatrix
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Describer is a kind of Fixture that can describe itself, for example by
+// listing the directories, PIDs and addresses it uses. The description is
+// printed when a fixture is kept with -tm.keep-failed, or when pausing with
+// -tm.pause-on-fail.
+type Describer interface {
+	Describe() string
+}
+
+// CleanupCommander is a kind of Fixture that can provide a shell command to
+// clean it up by hand. The command is printed when a fixture is kept with
+// -tm.keep-failed.
+type CleanupCommander interface {
+	CleanupCommand() string
+}
+
+// describeFixture returns a description of f, including its cleanup command
+// if it has one. Fixtures which do not implement Describer are formatted
+// with %+v.
+func describeFixture(f Fixture) string {
+	desc := fmt.Sprintf("%+v", f)
+	if d, ok := f.(Describer); ok {
+		desc = d.Describe()
+	}
+	if c, ok := f.(CleanupCommander); ok {
+		desc += "\ncleanup: " + c.CleanupCommand()
+	}
+	return desc
+}
+
+// finishFixture tears down fix once t has finished. If t failed, it first
+// pauses if -tm.pause-on-fail is set, and then if -tm.keep-failed is set it
+// describes fix and leaves it running instead of tearing it down.
+func (pf *Runner) finishFixture(t *testing.T, c Scenario, fix Fixture, tr *teardownTracker, teardown func(context.Context, *testing.T, bool) error) {
+	t.Helper()
+	var keep bool
+	if t.Failed() {
+		if *pauseOnFailFlag {
+			pf.parent.pause(t.Name(), c, fix)
+		}
+		if *keepFailedFlag {
+			keep = true
+			pf.keepFixture(t, c, fix)
+		}
+	}
+	pf.runTeardown(t, c, fix, tr, func(ctx context.Context, t *testing.T) error {
+		return teardown(ctx, t, keep)
+	})
+}
+
+// keepFixture records that fix was kept after t failed, and logs its
+// description.
+func (pf *Runner) keepFixture(t *testing.T, c Scenario, fix Fixture) {
+	t.Helper()
+	desc := describeFixture(fix) + sandboxDescription(t.Name())
+	t.Logf("keeping fixture and Preparable values for scenario %s (-tm.keep-failed):\n%s", c, desc)
+	pf.keptFixturesMu.Lock()
+	defer pf.keptFixturesMu.Unlock()
+	pf.keptFixtures[t.Name()] = desc
+}
+
+// kept returns true if the fixture of the named test was kept.
+func (pf *Runner) kept(name string) bool {
+	pf.keptFixturesMu.Lock()
+	defer pf.keptFixturesMu.Unlock()
+	_, kept := pf.keptFixtures[name]
+	return kept
+}
+
+// releaseOwned releases the addresses, processes and sandbox owned by the
+// fixture of t, unless the fixture was kept.
+func (pf *Runner) releaseOwned(t *testing.T) {
+	if !pf.kept(t.Name()) {
+		pf.parent.releaseOwner(t.Name())
+	}
+}
+
+// releaseValues releases the Preparable values retained by t, unless its
+// fixture was kept, in which case they are never torn down.
+func (pf *Runner) releaseValues(t *testing.T, values []*preparedValue) {
+	t.Helper()
+	if !pf.kept(t.Name()) {
+		pf.parent.releaseValues(t, values)
+	}
+}
+
+// keptSlice returns a description of each fixture kept after a failed test,
+// sorted by test name. Descriptions spanning several lines are indented.
+func (pf *Runner) keptSlice() []string {
+	pf.keptFixturesMu.Lock()
+	defer pf.keptFixturesMu.Unlock()
+	var s []string
+	for name, desc := range pf.keptFixtures {
+		s = append(s, name+"\n    "+strings.ReplaceAll(desc, "\n", "\n    "))
+	}
+	sort.Strings(s)
+	return s
+}
+
+// pauseInput is read by pause to wait for the developer to press enter.
+var pauseInput io.Reader = os.Stdin
+
+// pause prints a description of fix, and waits until a line is read from
+// pauseInput, the input is exhausted, or the run is interrupted. Only one
+// test pauses at a time; others wait their turn.
+func (s *supervisor) pause(name string, c Scenario, fix Fixture) {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	if s.interrupted() {
+		return
+	}
+	rtLog("PAUSED: %s failed in scenario %s. Fixture:\n%s\nPress enter to continue...",
+		name, c, describeFixture(fix)+sandboxDescription(name))
+	done := make(chan error, 1)
+	go func() { done <- readLine(pauseInput) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			rtLog("Not pausing: reading standard input: %s", err)
+		}
+	case <-s.ctx.Done():
+	}
+}
+
+// readLine reads from r up to and including the next newline. It reads a
+// byte at a time so that nothing after the newline is consumed.
+func readLine(r io.Reader) error {
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+		if b[0] == '\n' {
+			return nil
+		}
+	}
+}
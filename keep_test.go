@@ -0,0 +1,140 @@
+package testmatrix
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type describedFixture struct{}
+
+func (describedFixture) Describe() string       { return "dir: /tmp/x\npid: 42" }
+func (describedFixture) CleanupCommand() string { return "kill 42 && rm -rf /tmp/x" }
+
+func TestDescribeFixture(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		fixture Fixture
+		want    string
+	}{
+		{describedFixture{}, "dir: /tmp/x\npid: 42\ncleanup: kill 42 && rm -rf /tmp/x"},
+		{struct{ Addr string }{"127.0.0.1:80"}, "{Addr:127.0.0.1:80}"},
+	}
+	for _, tc := range cases {
+		if got := describeFixture(tc.fixture); got != tc.want {
+			t.Errorf("got description %q; want %q", got, tc.want)
+		}
+	}
+}
+
+func TestRunner_keptSlice(t *testing.T) {
+	t.Parallel()
+	pf := &Runner{keptFixtures: map[string]string{
+		"TestB/x": "one\ntwo",
+		"TestA/x": "three",
+	}}
+	got := strings.Join(pf.keptSlice(), "|")
+	want := "TestA/x\n    three|TestB/x\n    one\n    two"
+	if got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestSupervisor_releaseShared_keep(t *testing.T) {
+	t.Parallel()
+	s := newSupervisor()
+	sf := s.retainShared("k")
+	s.retainShared("k")
+	sf.created = true
+	if s.releaseShared(sf, true) {
+		t.Errorf("first release returned true; want false")
+	}
+	if s.releaseShared(sf, false) {
+		t.Errorf("last release of kept fixture returned true; want false")
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	t.Parallel()
+	r := strings.NewReader("first\nsecond\n")
+	if err := readLine(r); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Len(); got != len("second\n") {
+		t.Errorf("got %d bytes left after reading first line; want %d", got, len("second\n"))
+	}
+	if err := readLine(strings.NewReader("")); err == nil {
+		t.Errorf("got nil error reading empty input; want error")
+	}
+}
+
+func TestSupervisor_pause_interrupted(t *testing.T) {
+	t.Parallel()
+	s := newSupervisor()
+	s.interrupt()
+	done := make(chan struct{})
+	go func() {
+		s.pause("TestA", Scenario{}, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("pause blocked after the run was interrupted")
+	}
+}
+
+// printingFixture prints when it is torn down.
+type printingFixture struct{ name string }
+
+func (f printingFixture) TeardownContext(context.Context) error {
+	fmt.Printf("fixture torn down: %s\n", f.name)
+	return nil
+}
+
+// printingPreparable prints when it is torn down.
+type printingPreparable struct{}
+
+func (printingPreparable) SetUp(context.Context) (interface{}, error) { return nil, nil }
+
+func (printingPreparable) TearDown(context.Context, interface{}) error {
+	fmt.Println("preparable torn down")
+	return nil
+}
+
+func TestHelper_keepFailed(t *testing.T) {
+	helperTest(t)
+	m := New(Dim("tool", "", Values{"p": printingPreparable{}}), makeTestDim(1, 2))
+	r := m.NewRunner(t)
+	r.Run("test", func(t *testing.T, s Scenario) Fixture {
+		return printingFixture{t.Name()}
+	}, func(t *testing.T, f Fixture) {
+		if strings.Contains(t.Name(), "/dim1val1/") {
+			t.Error("failed")
+		}
+	})
+}
+
+func TestRunner_Run_keepFailed(t *testing.T) {
+	t.Parallel()
+	out := runHelperTest(t, "TestHelper_keepFailed", "-tm.keep-failed")
+	for _, want := range []string{
+		"--- FAIL: TestHelper_keepFailed/p/dim1val1/test",
+		"keeping fixture and Preparable values for scenario",
+		"fixture torn down: TestHelper_keepFailed/p/dim1val2/test",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got output:\n%s\nwant it to contain %q", out, want)
+		}
+	}
+	for _, notWant := range []string{
+		"fixture torn down: TestHelper_keepFailed/p/dim1val1/test",
+		"preparable torn down",
+	} {
+		if strings.Contains(out, notWant) {
+			t.Errorf("got output:\n%s\nwant it not to contain %q", out, notWant)
+		}
+	}
+}
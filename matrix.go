@@ -22,6 +22,14 @@ type Matrix struct {
 	orderedDimensionNames []string
 	orderedDimensionDescs []string
 	dimensions            Dimensions
+	defaults              map[string]string
+	sticky                map[string]bool
+	strategy              Strategy
+	generator             ScenarioGenerator
+	parallelism           Parallelism
+	// resources maps the names of resources declared using Resource to
+	// their capacities.
+	resources map[string]int
 }
 
 // Scenario is a single combination of values from a Matrix.
@@ -43,9 +51,11 @@ func New(dimensions ...Dimension) Matrix {
 	m := Matrix{
 		sup:        newSupervisor(),
 		dimensions: Dimensions{},
+		defaults:   map[string]string{},
+		sticky:     map[string]bool{},
 	}
 	for _, d := range dimensions {
-		m.addDimension(d.name, d.desc, d.values)
+		m.addDimension(d)
 	}
 	return m
 }
@@ -99,16 +109,26 @@ func (m Matrix) PrintDimensions() {
 // The values are a map of short value names to concrete representations, which
 // are passed to tests. The names of values map to parts of the sub-test path
 // for 'go test -run' flag.
-func (m *Matrix) addDimension(name, desc string, values Values) {
+func (m *Matrix) addDimension(d Dimension) {
+	name, values := d.name, d.values
 	if _, ok := m.dimensions[name]; ok {
 		panic(fmt.Sprintf("duplicate dimension name %q", name))
 	}
 	if len(values) == 0 {
 		panic(fmt.Sprintf("no values for dimension %q", name))
 	}
+	if _, ok := values[d.def]; d.def != "" && !ok {
+		panic(fmt.Sprintf("default value %q not in dimension %q", d.def, name))
+	}
 	m.dimensions[name] = values
+	if d.def != "" {
+		m.defaults[name] = d.def
+	}
+	if d.sticky {
+		m.sticky[name] = true
+	}
 	m.orderedDimensionNames = append(m.orderedDimensionNames, name)
-	m.orderedDimensionDescs = append(m.orderedDimensionDescs, desc)
+	m.orderedDimensionDescs = append(m.orderedDimensionDescs, d.desc)
 }
 
 func (m Matrix) clone(include func(dimension, value string) bool) Matrix {
@@ -126,60 +146,35 @@ func (m Matrix) clone(include func(dimension, value string) bool) Matrix {
 	return n
 }
 
+// scenarios returns the scenarios to run, as generated by the effective
+// ScenarioGenerator.
 func (m *Matrix) scenarios() []Scenario {
-	combos := [][]Scenario{}
-	for _, d := range m.orderedDimensionNames {
-		c := []Scenario{}
-		dim := m.dimensions[d]
-		valNames := []string{}
-		for name := range dim {
-			valNames = append(valNames, name)
-		}
-		sort.Strings(valNames)
-		for _, name := range valNames {
-			c = append(c, Scenario{
-				Binding{
-					Dimension: d,
-					Name:      name,
-					Value:     dim[name],
-				},
-			})
-		}
-		combos = append(combos, c)
-	}
-	return product(combos...)
+	return m.effectiveGenerator().Scenarios(m.dimensionList())
 }
 
-func product(slices ...[]Scenario) []Scenario {
-	res := slices[0]
-	for _, s := range slices[1:] {
-		res = mult(res, s)
-	}
-	return res
-}
-
-func mult(a, b []Scenario) []Scenario {
-	res := make([][]Scenario, len(a)*len(b))
-	n := 0
-	for _, aa := range a {
-		for _, bb := range b {
-			res[n] = []Scenario{aa, bb}
-			n++
+// dimensionList returns the dimensions of this matrix, in order.
+func (m *Matrix) dimensionList() []Dimension {
+	dims := make([]Dimension, len(m.orderedDimensionNames))
+	for i, name := range m.orderedDimensionNames {
+		dims[i] = Dimension{
+			name:   name,
+			desc:   m.orderedDimensionDescs[i],
+			values: m.dimensions[name],
+			def:    m.defaults[name],
+			sticky: m.sticky[name],
 		}
 	}
-	slice := make([]Scenario, len(res))
-	for i, r := range res {
-		slice[i] = concat(r)
-	}
-	return slice
+	return dims
 }
 
-func concat(scenarios []Scenario) Scenario {
-	res := scenarios[0]
-	for _, c := range scenarios[1:] {
-		res = append(res, c...)
+// sortedValueNames returns the names of values in sort order.
+func sortedValueNames(values Values) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
 	}
-	return res
+	sort.Strings(names)
+	return names
 }
 
 // String returns the sub-test path of this Scenario. E.g.
@@ -58,6 +58,11 @@ func TestNew_error(t *testing.T) {
 				Dim("dim2", "", Values{}),
 			)
 		}, `no values for dimension "dim2"`},
+		{"baddefault", func() Matrix {
+			return New(
+				Dim("dim1", "", Values{"a": struct{}{}}).WithDefault("b"),
+			)
+		}, `default value "b" not in dimension "dim1"`},
 	}
 	for _, tc := range cases {
 		tc := tc
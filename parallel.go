@@ -0,0 +1,198 @@
+package testmatrix
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// Parallelism controls whether tests run in parallel, and if so how many may
+// run at once. The zero value means unset, and behaves like Parallel.
+type Parallelism struct {
+	serial bool
+	// max is the maximum number of tests to run at once, or 0 for no limit
+	// beyond -test.parallel.
+	max int
+	set bool
+}
+
+var (
+	// Serial runs tests one at a time, without calling t.Parallel, for
+	// tests that mutate global state. If set on a Matrix or Runner, the
+	// top-level test is not run in parallel with other top-level tests
+	// either.
+	Serial = Parallelism{serial: true, set: true}
+	// Parallel runs tests in parallel, limited only by -test.parallel.
+	// This is the default.
+	Parallel = Parallelism{set: true}
+)
+
+// MaxParallel runs tests in parallel, with at most n running at once,
+// independently of -test.parallel. The limit applies to all tests governed
+// by the setting: those of a Matrix, Runner or single call to Runner.Run,
+// according to where it was set. It panics if n is less than 1.
+func MaxParallel(n int) Parallelism {
+	if n < 1 {
+		panic(fmt.Sprintf("MaxParallel(%d): n must be at least 1", n))
+	}
+	return Parallelism{max: n, set: true}
+}
+
+// String returns "serial", "parallel", or the maximum number of tests to run
+// at once.
+func (p Parallelism) String() string {
+	switch {
+	case p.serial:
+		return "serial"
+	case p.max != 0:
+		return strconv.Itoa(p.max)
+	}
+	return "parallel"
+}
+
+// Set parses s as a Parallelism, as formatted by String. It implements
+// flag.Value.
+func (p *Parallelism) Set(s string) error {
+	switch s {
+	case "serial":
+		*p = Serial
+		return nil
+	case "parallel":
+		*p = Parallel
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return fmt.Errorf("want serial, parallel, or a number at least 1; got %q", s)
+	}
+	*p = MaxParallel(n)
+	return nil
+}
+
+// WithParallelism returns a new Matrix based on m whose tests run with
+// parallelism p. It is overridden by the WithParallelism RunOption, and by
+// -tm.parallel.
+func (m Matrix) WithParallelism(p Parallelism) Matrix {
+	m.parallelism = p
+	return m
+}
+
+// WithParallelism runs tests with parallelism p. Passed to NewRunner, it
+// applies to all tests of that Runner; passed to Runner.Run, to that call
+// only. It is overridden by -tm.parallel.
+func WithParallelism(p Parallelism) RunOption {
+	return func(o *runOptions) {
+		o.parallelism = p
+	}
+}
+
+// semaphore limits the number of tests running at once. A nil semaphore
+// imposes no limit.
+type semaphore chan struct{}
+
+func newSemaphore(p Parallelism) semaphore {
+	if p.max == 0 {
+		return nil
+	}
+	return make(semaphore, p.max)
+}
+
+func (s semaphore) acquire() {
+	if s != nil {
+		s <- struct{}{}
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// runnerParallelism returns the parallelism governing the whole of pf, which
+// is that set by -tm.parallel, or failing that passed to NewRunner, or
+// failing that set on its Matrix.
+func (pf *Runner) runnerParallelism() Parallelism {
+	switch {
+	case parallelFlag.set:
+		return parallelFlag
+	case pf.options.parallelism.set:
+		return pf.options.parallelism
+	}
+	return pf.matrix.parallelism
+}
+
+// parallelism returns the parallelism of a call to Run with options o, and
+// the semaphore limiting it. The semaphore is shared by all tests governed by
+// the same setting.
+func (pf *Runner) parallelism(o runOptions) (Parallelism, semaphore) {
+	switch {
+	case parallelFlag.set:
+		return parallelFlag, pf.parent.semaphore("flag", parallelFlag)
+	case o.parallelism.set:
+		return o.parallelism, newSemaphore(o.parallelism)
+	case pf.options.parallelism.set:
+		pf.slotsOnce.Do(func() { pf.slots = newSemaphore(pf.options.parallelism) })
+		return pf.options.parallelism, pf.slots
+	}
+	p := pf.matrix.parallelism
+	return p, pf.parent.semaphore("matrix:"+p.String(), p)
+}
+
+// semaphore returns the semaphore for key, creating it for p if necessary.
+func (s *supervisor) semaphore(key string, p Parallelism) semaphore {
+	s.semaphoresMu.Lock()
+	defer s.semaphoresMu.Unlock()
+	sem, ok := s.semaphores[key]
+	if !ok {
+		sem = newSemaphore(p)
+		s.semaphores[key] = sem
+	}
+	return sem
+}
+
+// startParallel calls t.Parallel unless p is serial.
+func startParallel(t *testing.T, p Parallelism) {
+	if !p.serial {
+		t.Parallel()
+	}
+}
+
+// hold retains the Preparable values, scenarios and, if o shares fixtures,
+// the shared fixtures of scenarios until parent and all its subtests have
+// finished, if o runs tests serially. Serial tests each retain and release
+// them in turn, so without a hold they would be torn down and set up again
+// between tests. Anything no longer in use once parent has finished is torn
+// down then, and OnScenarioEnd is passed parent. Parallel tests all retain
+// them before any is released to run, so need no hold. hold does nothing
+// unless parent is a *testing.T, which it always is outside this package's
+// own tests.
+func (pf *Runner) hold(parent T, scenarios []Scenario, o runOptions) {
+	t, ok := parent.(*testing.T)
+	if !ok || !o.parallelism.serial {
+		return
+	}
+	values := make([][]*preparedValue, len(scenarios))
+	states := make([]*scenarioState, len(scenarios))
+	shared := make([]*sharedFixture, len(scenarios))
+	for i, c := range scenarios {
+		values[i] = pf.parent.retainValues(c)
+		states[i] = pf.parent.retainScenario(c)
+		if o.shared {
+			shared[i] = pf.parent.retainShared(pf.sharedKey(c, o))
+		}
+	}
+	t.Cleanup(func() {
+		for i, c := range scenarios {
+			if sf := shared[i]; sf != nil && pf.parent.releaseShared(sf, false) {
+				pf.runTeardown(t, c, sf.fixture, &teardownTracker{}, func(ctx context.Context, t *testing.T) error {
+					defer pf.parent.releaseOwner(sf.owner())
+					return pf.teardown(ctx, t, sf.fixture)
+				})
+			}
+			pf.endScenario(t, c, states[i])
+			pf.parent.releaseValues(t, values[i])
+		}
+	})
+}
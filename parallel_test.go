@@ -0,0 +1,127 @@
+package testmatrix
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallelism_Set(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		in      string
+		want    Parallelism
+		wantErr bool
+	}{
+		{"serial", Serial, false},
+		{"parallel", Parallel, false},
+		{"3", MaxParallel(3), false},
+		{"0", Parallelism{}, true},
+		{"lots", Parallelism{}, true},
+	}
+	for _, tc := range cases {
+		var got Parallelism
+		err := got.Set(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Set(%q) got error %v; want error: %t", tc.in, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Set(%q) = %v; want %v", tc.in, got, tc.want)
+		}
+		if !tc.wantErr && got.String() != tc.in {
+			t.Errorf("Set(%q).String() = %q", tc.in, got.String())
+		}
+	}
+}
+
+// concurrencyTest returns a Test which records the maximum number of tests
+// running it at once in max.
+func concurrencyTest(current, max *int32) Test {
+	return func(t *testing.T, f Fixture) {
+		n := atomic.AddInt32(current, 1)
+		defer atomic.AddInt32(current, -1)
+		for {
+			m := atomic.LoadInt32(max)
+			if n <= m || atomic.CompareAndSwapInt32(max, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunner_Run_parallelism(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name        string
+		matrix      Parallelism
+		runner, run []RunOption
+		wantMax     int32
+	}{
+		{"serial", Parallelism{}, nil, []RunOption{WithParallelism(Serial)}, 1},
+		{"max2", Parallelism{}, nil, []RunOption{WithParallelism(MaxParallel(2))}, 2},
+		{"runner", Parallelism{}, []RunOption{WithParallelism(Serial)}, nil, 1},
+		{"matrix", MaxParallel(3), nil, nil, 3},
+		{"override", Serial, nil, []RunOption{WithParallelism(MaxParallel(2))}, 2},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			m := New(makeTestDim(1, 6)).WithParallelism(tc.matrix)
+			var current, max int32
+			var mu sync.Mutex
+			var order []string
+			runGroup(t, "run", func(t *testing.T) {
+				r := m.NewRunner(t, tc.runner...)
+				test := concurrencyTest(&current, &max)
+				r.Run("test", nil, func(t *testing.T, f Fixture) {
+					mu.Lock()
+					order = append(order, f.(*ComposedFixture).Scenario.String())
+					mu.Unlock()
+					test(t, f)
+				}, tc.run...)
+			})
+			if max > tc.wantMax {
+				t.Errorf("got %d tests at once; want at most %d", max, tc.wantMax)
+			}
+			if tc.wantMax == 1 {
+				want := scenarioStrings(m.scenarios())
+				for i := range want {
+					if order[i] != want[i] {
+						t.Fatalf("got serial order %q; want %q", order, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRunner_Run_serialHold(t *testing.T) {
+	t.Parallel()
+	p := &testPreparable{name: "p"}
+	m := New(Dim("tool", "", Values{"p": p}), makeTestDim(1, 2))
+	var builds int32
+	runGroup(t, "run", func(t *testing.T) {
+		r := m.NewRunner(t, WithParallelism(Serial))
+		for _, name := range []string{"one", "two", "three"} {
+			r.Run(name, func(t *testing.T, s Scenario) Fixture {
+				atomic.AddInt32(&builds, 1)
+				return PreparedResource(t, "tool")
+			}, func(t *testing.T, f Fixture) {
+				if f != "resource-p" {
+					t.Errorf("got resource %v; want resource-p", f)
+				}
+			}, Shared())
+		}
+	})
+	if p.setUps != 1 || p.tearDowns != 1 {
+		t.Errorf("got %d SetUps and %d TearDowns; want 1 of each", p.setUps, p.tearDowns)
+	}
+	// One shared fixture per scenario.
+	if builds != 2 {
+		t.Errorf("built shared fixtures %d times; want 2", builds)
+	}
+}
@@ -0,0 +1,172 @@
+package testmatrix
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Prefetch makes up to n fixtures for a call to Runner.Run be built ahead of
+// time by background workers, while earlier tests are still running. Without
+// it, each fixture is built in turn before its test is released to run in
+// parallel, so setup does not overlap with test execution.
+//
+// At most n fixtures are being built or waiting for their tests at once. A
+// test whose fixture has not been started by a worker when it is released
+// builds the fixture itself.
+//
+// Prefetch only applies to Runner.RunE and Runner.RunContext. Prefetched
+// fixtures are built in a different goroutine from their test, so their
+// factories must return errors rather than call t.Fatal, t.Skip or any other
+// method which must be called from the test goroutine; if they do, the test
+// fails. Runner.Run, whose factories can only report errors that way, always
+// builds fixtures on the test goroutine.
+//
+// Prefetch overrides Opts.Prefetch, and is overridden by -tm.prefetch.
+func Prefetch(n int) RunOption {
+	return func(o *runOptions) {
+		o.prefetch = n
+		o.prefetchSet = true
+	}
+}
+
+// prefetchCount returns the number of fixtures to prefetch for a call to
+// Runner.Run with options o.
+func prefetchCount(o runOptions) int {
+	switch {
+	case o.noPrefetch:
+		return 0
+	case flagSet("tm.prefetch"):
+		return *prefetchFlag
+	case o.prefetchSet:
+		return o.prefetch
+	}
+	return opts.Prefetch
+}
+
+// flagSet returns true if the named flag was set on the command line.
+func flagSet(name string) bool {
+	var set bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// errFactoryExited is the error of a prefetched fixture whose factory called
+// runtime.Goexit, usually via t.FailNow or t.SkipNow, which it must not do.
+var errFactoryExited = errors.New("prefetched fixture factory called t.FailNow or t.SkipNow; return an error instead")
+
+// buildFunc builds a fixture, returning it along with its teardown func.
+type buildFunc func() (Fixture, func(context.Context, *testing.T, bool) error, error)
+
+// prefetcher builds fixtures for the tests of a single call to Runner.Run in
+// the background, in the order the tests were submitted. A nil *prefetcher
+// builds nothing in the background.
+type prefetcher struct {
+	jobs chan *prefetchJob
+	// slots has one entry for each fixture being built, or built and not
+	// yet taken by its test.
+	slots chan struct{}
+}
+
+// newPrefetcher returns a prefetcher for up to jobs fixtures, building at
+// most n ahead. It returns nil if n is not positive.
+func newPrefetcher(n, jobs int) *prefetcher {
+	if n <= 0 {
+		return nil
+	}
+	p := &prefetcher{
+		jobs:  make(chan *prefetchJob, jobs),
+		slots: make(chan struct{}, n),
+	}
+	go p.dispatch()
+	return p
+}
+
+// dispatch starts building each submitted job that has not been claimed by
+// its test, waiting for a free slot before each.
+func (p *prefetcher) dispatch() {
+	for j := range p.jobs {
+		p.slots <- struct{}{}
+		if !j.claim() {
+			<-p.slots
+			continue
+		}
+		j.release = func() { <-p.slots }
+		go func(j *prefetchJob) {
+			// The build labels the worker as its test; reset the labels once
+			// it is done, so the worker is no longer attributed to the test.
+			defer pprof.SetGoroutineLabels(context.Background())
+			j.build()
+		}(j)
+	}
+}
+
+// close signals that no more jobs will be submitted.
+func (p *prefetcher) close() {
+	if p != nil {
+		close(p.jobs)
+	}
+}
+
+// submit returns a job to build a fixture using build, queueing it to be
+// built in the background if p is not nil. Every job must be taken.
+func (p *prefetcher) submit(build buildFunc) *prefetchJob {
+	j := newPrefetchJob(build)
+	if p != nil {
+		p.jobs <- j
+	}
+	return j
+}
+
+// newPrefetchJob returns a job to build a fixture using build, which is not
+// queued to be built in the background.
+func newPrefetchJob(build buildFunc) *prefetchJob {
+	return &prefetchJob{make: build, done: make(chan struct{}), release: func() {}}
+}
+
+// prefetchJob is a fixture being built for a single test.
+type prefetchJob struct {
+	make buildFunc
+	// claimed is 1 once either the test or a worker has claimed the job.
+	// It must be accessed atomically.
+	claimed     int32
+	done        chan struct{}
+	release     func()
+	releaseOnce sync.Once
+	fixture     Fixture
+	teardown    func(context.Context, *testing.T, bool) error
+	err         error
+}
+
+func (j *prefetchJob) claim() bool {
+	return atomic.CompareAndSwapInt32(&j.claimed, 0, 1)
+}
+
+// build builds the fixture and closes done. It must only be called once the
+// job has been claimed.
+func (j *prefetchJob) build() {
+	defer close(j.done)
+	// If make calls runtime.Goexit, the assignment below never happens.
+	j.err = errFactoryExited
+	j.fixture, j.teardown, j.err = j.make()
+}
+
+// take returns the built fixture, building it in the calling goroutine if no
+// worker has started building it, or waiting for the worker if one has. It
+// may be called more than once.
+func (j *prefetchJob) take() (Fixture, func(context.Context, *testing.T, bool) error, error) {
+	if j.claim() {
+		j.build()
+	}
+	<-j.done
+	j.releaseOnce.Do(j.release)
+	return j.fixture, j.teardown, j.err
+}
@@ -0,0 +1,125 @@
+package testmatrix
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// countingBuild returns a buildFunc which blocks until release is closed,
+// recording the maximum number of builds in progress at once in max.
+func countingBuild(fix Fixture, release chan struct{}, current, max *int32) buildFunc {
+	return func() (Fixture, func(context.Context, *testing.T, bool) error, error) {
+		n := atomic.AddInt32(current, 1)
+		for {
+			m := atomic.LoadInt32(max)
+			if n <= m || atomic.CompareAndSwapInt32(max, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(current, -1)
+		return fix, nil, nil
+	}
+}
+
+func TestPrefetcher(t *testing.T) {
+	t.Parallel()
+	const n, jobs = 2, 5
+	p := newPrefetcher(n, jobs)
+	release := make(chan struct{})
+	var current, max int32
+	var js []*prefetchJob
+	for i := 0; i < jobs; i++ {
+		js = append(js, p.submit(countingBuild(i, release, &current, &max)))
+	}
+	p.close()
+	close(release)
+	for i, j := range js {
+		fix, _, err := j.take()
+		if fix != i || err != nil {
+			t.Errorf("job %d: got fixture %v, error %v; want %d, nil", i, fix, err, i)
+		}
+	}
+	if max > n {
+		t.Errorf("got %d builds at once; want at most %d", max, n)
+	}
+}
+
+func TestPrefetcher_steal(t *testing.T) {
+	t.Parallel()
+	p := newPrefetcher(1, 2)
+	defer p.close()
+	block := make(chan struct{})
+	var current, max int32
+	first := p.submit(countingBuild("first", block, &current, &max))
+	second := p.submit(func() (Fixture, func(context.Context, *testing.T, bool) error, error) {
+		return "second", nil, nil
+	})
+	// The only worker slot is held by first, so taking second must build
+	// it here rather than waiting.
+	if fix, _, _ := second.take(); fix != "second" {
+		t.Errorf("got fixture %v; want second", fix)
+	}
+	close(block)
+	if fix, _, _ := first.take(); fix != "first" {
+		t.Errorf("got fixture %v; want first", fix)
+	}
+}
+
+func TestPrefetchJob_goexit(t *testing.T) {
+	t.Parallel()
+	p := newPrefetcher(1, 1)
+	j := p.submit(func() (Fixture, func(context.Context, *testing.T, bool) error, error) {
+		runtime.Goexit()
+		return nil, nil, nil
+	})
+	p.close()
+	// Wait for the worker, rather than building it in this goroutine.
+	<-j.done
+	if _, _, err := j.take(); err != errFactoryExited {
+		t.Errorf("got error %v; want %v", err, errFactoryExited)
+	}
+}
+
+func TestRunner_Run_prefetch(t *testing.T) {
+	t.Parallel()
+	for _, options := range [][]RunOption{{Prefetch(2)}, {Prefetch(2), Shared()}} {
+		options := options
+		m := New(makeTestDim(1, 2), makeTestDim(2, 2))
+		var mu sync.Mutex
+		var fixtures []*testFixture
+		factory := testFixtureFactory(&mu, &fixtures)
+		runGroup(t, "run", func(t *testing.T) {
+			r := m.NewRunner(t)
+			r.RunE("test", fixtureFactoryE(factory), func(t *testing.T, f Fixture) {
+				if f == nil {
+					t.Errorf("got nil fixture")
+				}
+			}, options...)
+		})
+		if len(fixtures) != 4 {
+			t.Errorf("got %d fixtures; want 4", len(fixtures))
+		}
+		for _, f := range fixtures {
+			if f.teardowns != 1 {
+				t.Errorf("fixture for %s torn down %d times; want 1", f.scenario, f.teardowns)
+			}
+		}
+	}
+}
+
+func TestPrefetchCount_run(t *testing.T) {
+	t.Parallel()
+	var o runOptions
+	m := New(makeTestDim(1, 1))
+	runGroup(t, "run", func(t *testing.T) {
+		r := m.NewRunner(t)
+		r.Run("test", nil, func(*testing.T, Fixture) {}, Prefetch(2), func(opts *runOptions) { o = *opts })
+	})
+	if n := prefetchCount(o); n != 0 {
+		t.Errorf("got Run prefetching %d fixtures; want 0", n)
+	}
+}
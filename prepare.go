@@ -0,0 +1,180 @@
+package testmatrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Preparable is implemented by dimension values that need expensive
+// preparation before they can be used, for example unpacking a binary or
+// compiling a particular version of a tool.
+//
+// SetUp is called once per value, lazily, the first time a scenario using
+// that value starts. The resource it returns is available to the
+// FixtureFactory via PreparedResource. TearDown is called with that resource
+// once the last test using the value has finished, or for Serial tests,
+// once the test which ran them has finished. Values used by a failed test
+// whose fixture is kept with -tm.keep-failed are never torn down. If SetUp
+// returns an error,
+// every test in a scenario using that value fails with an infrastructure
+// error, or is skipped if the error wraps ErrUnsupported.
+type Preparable interface {
+	SetUp(ctx context.Context) (resource interface{}, err error)
+	TearDown(ctx context.Context, resource interface{}) error
+}
+
+// defaultTeardownTimeout is how long teardowns are given to complete, unless
+// configured otherwise using Opts.TeardownTimeout or -tm.teardown-timeout.
+const defaultTeardownTimeout = 10 * time.Second
+
+// preparedValue is a Preparable dimension value shared by all tests whose
+// scenario includes it.
+type preparedValue struct {
+	key   string
+	value Preparable
+	// refs is the number of tests currently using this value.
+	// It is guarded by supervisor.preparedMu.
+	refs     int
+	once     sync.Once
+	ready    bool
+	resource interface{}
+	err      error
+}
+
+// retainValues returns a preparedValue for each Preparable value in c, with
+// its reference count incremented. Values which are not Preparable have a nil
+// entry.
+func (s *supervisor) retainValues(c Scenario) []*preparedValue {
+	s.preparedMu.Lock()
+	defer s.preparedMu.Unlock()
+	values := make([]*preparedValue, len(c))
+	for i, b := range c {
+		p, ok := b.Value.(Preparable)
+		if !ok {
+			continue
+		}
+		key := b.Dimension + "=" + b.Name
+		pv, ok := s.prepared[key]
+		if !ok {
+			pv = &preparedValue{key: key, value: p}
+			s.prepared[key] = pv
+		}
+		pv.refs++
+		values[i] = pv
+	}
+	return values
+}
+
+// setUpValues sets up each of values if necessary. If any could not be set
+// up, it returns an error, which wraps ErrInfrastructure unless SetUp
+// returned an error wrapping ErrUnsupported.
+func (s *supervisor) setUpValues(values []*preparedValue) error {
+	// SetUp is passed the run context, so that it is cancelled if the run is
+	// interrupted.
+	for _, pv := range values {
+		if pv == nil {
+			continue
+		}
+		pv.once.Do(func() { pv.setUp(s.ctx) })
+		if !pv.ready {
+			if errors.Is(pv.err, ErrUnsupported) || errors.Is(pv.err, ErrInfrastructure) {
+				return fmt.Errorf("setting up %s: %w", pv.key, pv.err)
+			}
+			return fmt.Errorf("%w: setting up %s: %s", ErrInfrastructure, pv.key, pv.err)
+		}
+	}
+	return nil
+}
+
+// setUp sets up pv, recording a panic in SetUp as an infrastructure error,
+// so that every test using pv gets the same error.
+func (pv *preparedValue) setUp(ctx context.Context) {
+	defer func() {
+		if r := recover(); r != nil {
+			pv.err = fmt.Errorf("%w: panic in SetUp: %v\n%s", ErrInfrastructure, r, debug.Stack())
+		}
+	}()
+	pv.err = fmt.Errorf("SetUp did not return")
+	pv.resource, pv.err = pv.value.SetUp(ctx)
+	pv.ready = pv.err == nil
+}
+
+// releaseValues decrements the reference count of each value, tearing down
+// those no longer in use by any test. Teardown errors fail t.
+func (s *supervisor) releaseValues(t *testing.T, values []*preparedValue) {
+	t.Helper()
+	var release []*preparedValue
+	s.preparedMu.Lock()
+	for _, pv := range values {
+		if pv == nil {
+			continue
+		}
+		pv.refs--
+		if pv.refs != 0 {
+			continue
+		}
+		if s.prepared[pv.key] == pv {
+			delete(s.prepared, pv.key)
+		}
+		release = append(release, pv)
+	}
+	s.preparedMu.Unlock()
+	for _, pv := range release {
+		if err := pv.tearDown(); err != nil {
+			t.Errorf("tearing down %s: %s", pv.key, err)
+		}
+	}
+}
+
+// tearDown tears down pv if it was successfully set up.
+func (pv *preparedValue) tearDown() error {
+	// Prevent SetUp being called after this point.
+	pv.once.Do(func() {})
+	if !pv.ready {
+		return nil
+	}
+	ctx, cancel := teardownContext()
+	defer cancel()
+	return pv.value.TearDown(ctx, pv.resource)
+}
+
+// PreparedResource returns the resource prepared for the named dimension's
+// value in the scenario of t. It is nil unless the value implements
+// Preparable. t must be a test started by Runner.Run or Runner.RunE, or a
+// subtest of one, so PreparedResource can be called both from fixture
+// factories and tests.
+func PreparedResource(t *testing.T, dimension string) interface{} {
+	t.Helper()
+	resource, err := preparedResource(t.Name(), dimension)
+	if err != nil {
+		t.Fatalf("getting prepared resource: %s", err)
+	}
+	return resource
+}
+
+// preparedResource returns the resource prepared for the named dimension's
+// value in the scenario of the named test.
+func preparedResource(name, dimension string) (interface{}, error) {
+	_, rt, err := lookupRunningTest(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range rt.scenario {
+		if b.Dimension != dimension {
+			continue
+		}
+		rt.sup.preparedMu.Lock()
+		pv := rt.sup.prepared[b.Dimension+"="+b.Name]
+		rt.sup.preparedMu.Unlock()
+		if pv == nil || !pv.ready {
+			return nil, nil
+		}
+		return pv.resource, nil
+	}
+	return nil, fmt.Errorf("scenario %s contains no value for dimension %q", rt.scenario, dimension)
+}
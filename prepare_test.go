@@ -0,0 +1,98 @@
+package testmatrix
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// testPreparable counts calls to SetUp and TearDown.
+type testPreparable struct {
+	name               string
+	mu                 sync.Mutex
+	setUps, tearDowns  int
+	tornDownWithResult interface{}
+}
+
+func (p *testPreparable) SetUp(context.Context) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setUps++
+	return "resource-" + p.name, nil
+}
+
+func (p *testPreparable) TearDown(_ context.Context, resource interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tearDowns++
+	p.tornDownWithResult = resource
+	return nil
+}
+
+func TestRunner_Run_preparable(t *testing.T) {
+	t.Parallel()
+	p1, p2 := &testPreparable{name: "p1"}, &testPreparable{name: "p2"}
+	m := New(
+		Dim("tool", "", Values{"p1": p1, "p2": p2}),
+		makeTestDim(1, 2),
+	)
+	factory := func(t *testing.T, s Scenario) Fixture {
+		return PreparedResource(t, "tool")
+	}
+	runGroup(t, "run", func(t *testing.T) {
+		r := m.NewRunner(t)
+		for _, name := range []string{"one", "two"} {
+			r.Run(name, factory, func(t *testing.T, f Fixture) {
+				if f != "resource-p1" && f != "resource-p2" {
+					t.Errorf("got resource %v", f)
+				}
+			})
+		}
+	})
+	for _, p := range []*testPreparable{p1, p2} {
+		if p.setUps != 1 {
+			t.Errorf("%s set up %d times; want 1", p.name, p.setUps)
+		}
+		if p.tearDowns != 1 {
+			t.Errorf("%s torn down %d times; want 1", p.name, p.tearDowns)
+		}
+		if want := "resource-" + p.name; p.tornDownWithResult != want {
+			t.Errorf("%s torn down with %v; want %v", p.name, p.tornDownWithResult, want)
+		}
+	}
+}
+
+// panickingPreparable panics in SetUp, counting calls.
+type panickingPreparable struct {
+	setUps int32
+}
+
+func (p *panickingPreparable) SetUp(context.Context) (interface{}, error) {
+	atomic.AddInt32(&p.setUps, 1)
+	panic("boom")
+}
+
+func (p *panickingPreparable) TearDown(context.Context, interface{}) error { return nil }
+
+func TestSupervisor_setUpValues_panic(t *testing.T) {
+	t.Parallel()
+	s := newSupervisor()
+	p := &panickingPreparable{}
+	c := Scenario{{Dimension: "tool", Name: "p", Value: p}}
+	values := s.retainValues(c)
+	for i := 0; i < 2; i++ {
+		err := s.setUpValues(values)
+		if !errors.Is(err, ErrInfrastructure) {
+			t.Errorf("got error %v; want it to wrap ErrInfrastructure", err)
+		}
+		if want := "setting up tool=p: infrastructure error: panic in SetUp: boom"; err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v; want it to contain %q", err, want)
+		}
+	}
+	if n := atomic.LoadInt32(&p.setUps); n != 1 {
+		t.Errorf("SetUp called %d times; want 1", n)
+	}
+}
@@ -0,0 +1,340 @@
+package testmatrix
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Command describes a process to start using StartProcess.
+type Command struct {
+	// Name labels the process's output in the test log, and names its log
+	// file in the artifact directory. It defaults to the base name of Path.
+	Name string
+	// Path is the path of the binary to run.
+	Path string
+	// Args are the arguments to pass, not including the binary itself.
+	Args []string
+	// Env holds environment variables in the form "key=value", added to the
+	// environment of the test binary, and that of the fixture's Sandbox if
+	// it has one.
+	Env []string
+	// Dir is the working directory, defaulting to that of the test binary.
+	Dir string
+}
+
+// CommandConfigurer is implemented by dimension values which configure the
+// processes started by StartProcess in their scenarios, for example by
+// adding arguments or environment variables. ConfigureCommand is called for
+// each such value, in dimension order.
+type CommandConfigurer interface {
+	ConfigureCommand(cmd *Command)
+}
+
+// maxExitOutput is the most output included when a process exits
+// unexpectedly.
+const maxExitOutput = 64 << 10
+
+// Process is a process started by StartProcess.
+type Process struct {
+	name    string
+	cmd     *exec.Cmd
+	logPath string
+	output  syncBuffer
+	// done is closed once the process has exited and its output has been
+	// copied, after which err is set.
+	done chan struct{}
+	err  error
+	// stopping is set once the process is being stopped, so that its exit is
+	// expected.
+	mu       sync.Mutex
+	stopping bool
+}
+
+// StartProcess starts cmd for the fixture of t, which must be a test started
+// by Runner.Run or Runner.RunE, or a subtest of one. Values in t's scenario
+// which implement CommandConfigurer configure cmd first.
+//
+// The process runs in its own process group. Its stdout and stderr are
+// written to the test log while the test is running, and to a log file in
+// the test's artifact directory. If it exits before being stopped while the
+// test is running, the test fails with its output. If t is a subtest, output
+// and failures are reported in the test started by the Runner, which may
+// outlive t.
+//
+// Call Stop to stop the process when tearing down the fixture. In any case,
+// the whole process group is killed once the fixture which owns the process
+// has been torn down, even if the process itself has already exited. A
+// process started while building a shared fixture is owned by that fixture,
+// so outlives the test which built it. The process group is also killed if
+// the run is interrupted, or shortly before the go test deadline.
+func StartProcess(t *testing.T, cmd Command) *Process {
+	t.Helper()
+	p, err := StartProcessE(t, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// StartProcessE is like StartProcess, but returns an error wrapping
+// ErrInfrastructure instead of failing t, for use in a FixtureFactoryE.
+func StartProcessE(t *testing.T, cmd Command) (*Process, error) {
+	_, rt, err := lookupRunningTest(t.Name())
+	if err != nil {
+		return nil, fmt.Errorf("starting process: %w", err)
+	}
+	for _, b := range rt.scenario {
+		if c, ok := b.Value.(CommandConfigurer); ok {
+			c.ConfigureCommand(&cmd)
+		}
+	}
+	if cmd.Name == "" {
+		cmd.Name = filepath.Base(cmd.Path)
+	}
+	dir, err := artifactDir(t.Name())
+	if err != nil {
+		return nil, fmt.Errorf("starting %s: %w", cmd.Name, err)
+	}
+	p := &Process{
+		name:    cmd.Name,
+		logPath: filepath.Join(dir, artifactPath(cmd.Name)+".log"),
+		done:    make(chan struct{}),
+	}
+	logFile, err := os.Create(p.logPath)
+	if err != nil {
+		return nil, fmt.Errorf("starting %s: %w", cmd.Name, err)
+	}
+	name := t.Name()
+	out := &lineWriter{emit: func(line string) {
+		whileRunning(name, func(t *testing.T) { t.Logf("%s: %s", cmd.Name, line) })
+	}}
+	p.cmd = exec.Command(cmd.Path, cmd.Args...)
+	p.cmd.Env = os.Environ()
+	if sb := SandboxOf(t); sb != nil {
+		p.cmd.Env = append(p.cmd.Env, sb.Env()...)
+	}
+	p.cmd.Env = append(p.cmd.Env, cmd.Env...)
+	p.cmd.Dir = cmd.Dir
+	p.cmd.Stdout = multiWriter{&p.output, logFile, out}
+	p.cmd.Stderr = p.cmd.Stdout
+	setProcessGroup(p.cmd)
+	if err := p.cmd.Start(); err != nil {
+		logFile.Close()
+		return nil, fmt.Errorf("%w: starting %s: %s", ErrInfrastructure, cmd.Name, err)
+	}
+	rt.sup.onRelease(ownerOf(rt), p.kill)
+	go p.wait(t, rt.sup.ctx, logFile, out)
+	return p, nil
+}
+
+// wait waits for the process to exit, killing it if ctx is done or the
+// process deadline is reached first. If the process exits without being
+// stopped, t fails if it is still running.
+func (p *Process) wait(t *testing.T, ctx context.Context, logFile *os.File, out *lineWriter) {
+	exited := make(chan error, 1)
+	go func() { exited <- p.cmd.Wait() }()
+	var timeout <-chan time.Time
+	if deadline, ok := processDeadline(t); ok {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var err error
+	select {
+	case err = <-exited:
+	case <-ctx.Done():
+		p.kill()
+		err = <-exited
+	case <-timeout:
+		whileRunning(t.Name(), func(t *testing.T) {
+			t.Errorf("%s (pid %d) killed before the go test deadline", p.name, p.Pid())
+		})
+		p.kill()
+		err = <-exited
+	}
+	out.flush()
+	logFile.Close()
+	p.err = err
+	p.mu.Lock()
+	expected := p.stopping
+	p.mu.Unlock()
+	close(p.done)
+	if expected || ctx.Err() != nil {
+		return
+	}
+	whileRunning(t.Name(), func(t *testing.T) {
+		t.Errorf("%s (pid %d) exited unexpectedly: %v\noutput:\n%s",
+			p.name, p.Pid(), exitError(err), tail(p.output.Bytes(), maxExitOutput))
+	})
+}
+
+// processDeadline returns the time at which processes started by t are
+// killed, shortly before the go test deadline, so that processes are not left
+// running when the binary panics. It is not the scenario deadline of t, since
+// the process may be owned by a shared fixture which outlives t.
+func processDeadline(t *testing.T) (time.Time, bool) {
+	if deadline, ok := t.Deadline(); ok {
+		return deadline.Add(-time.Second), true
+	}
+	return time.Time{}, false
+}
+
+// Stop stops the process, first asking its process group to terminate, and
+// killing it if it has not exited by the deadline of ctx. It returns nil if
+// the process exited because it was stopped. The process group is asked to
+// terminate even if the process has already exited, since other processes
+// in the group may still be running.
+func (p *Process) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	p.stopping = true
+	p.mu.Unlock()
+	if err := terminateProcessGroup(p.cmd); err != nil {
+		p.kill()
+	}
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		p.kill()
+		<-p.done
+		return fmt.Errorf("%s (pid %d) killed after not stopping in time: %w", p.name, p.Pid(), ctx.Err())
+	}
+}
+
+// TeardownContext stops the process, so that a *Process can itself be used
+// as a fixture, or pushed onto a CleanupStack.
+func (p *Process) TeardownContext(ctx context.Context) error {
+	return p.Stop(ctx)
+}
+
+// kill kills the whole process group, without waiting. The group is killed
+// even if the process has already exited, so that no other process in it
+// outlives the fixture.
+func (p *Process) kill() {
+	p.mu.Lock()
+	p.stopping = true
+	p.mu.Unlock()
+	killProcessGroup(p.cmd)
+}
+
+// Pid returns the process ID, which is also its process group ID.
+func (p *Process) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+// Output returns all output written by the process so far.
+func (p *Process) Output() []byte {
+	return p.output.Bytes()
+}
+
+// OutputProbe returns a Probe which is ready once a line of the process's
+// output matches pattern. It panics if pattern is not a valid regular
+// expression.
+func (p *Process) OutputProbe(pattern string) Probe {
+	return MatchProbe(p.name+" output", regexp.MustCompile("(?m)"+pattern), func() ([]byte, error) {
+		select {
+		case <-p.done:
+			return nil, fmt.Errorf("%s exited: %v", p.name, exitError(p.err))
+		default:
+		}
+		return p.Output(), nil
+	})
+}
+
+// Describe describes the process, for use in describing fixtures kept with
+// -tm.keep-failed.
+func (p *Process) Describe() string {
+	return fmt.Sprintf("%s: pid %d, log %s", p.name, p.Pid(), p.logPath)
+}
+
+// Done returns a channel which is closed once the process has exited.
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+// exitError describes err returned from waiting for a process.
+func exitError(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+// tail returns at most the last n bytes of b.
+func tail(b []byte, n int) []byte {
+	if len(b) <= n {
+		return b
+	}
+	return append([]byte("..."), b[len(b)-n:]...)
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+// multiWriter writes to each of its writers, ignoring errors so that a
+// failure to log never blocks the process.
+type multiWriter []io.Writer
+
+func (w multiWriter) Write(p []byte) (int, error) {
+	for _, w := range w {
+		w.Write(p)
+	}
+	return len(p), nil
+}
+
+// lineWriter calls emit with each complete line written to it.
+type lineWriter struct {
+	mu      sync.Mutex
+	partial []byte
+	emit    func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i == -1 {
+			break
+		}
+		w.emit(strings.TrimSuffix(string(w.partial[:i]), "\r"))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush emits any final line not ending in a newline.
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) != 0 {
+		w.emit(string(w.partial))
+		w.partial = nil
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package testmatrix
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errNoProcessGroups = errors.New("process groups not supported")
+
+// setProcessGroup does nothing on this platform; only the process itself,
+// not its children, is killed.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcessGroup is not supported on this platform, so the process
+// is killed instead.
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return errNoProcessGroups
+}
+
+// killProcessGroup kills the process started by cmd.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,55 @@
+package testmatrix
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineWriter(t *testing.T) {
+	t.Parallel()
+	var lines []string
+	w := &lineWriter{emit: func(line string) { lines = append(lines, line) }}
+	w.Write([]byte("one\r\ntw"))
+	w.Write([]byte("o\nthree"))
+	w.flush()
+	if got := strings.Join(lines, "|"); got != "one|two|three" {
+		t.Errorf("got lines %q; want one|two|three", got)
+	}
+}
+
+func TestTail(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"0123456789", 4, "...6789"},
+	}
+	for _, tc := range cases {
+		if got := string(tail([]byte(tc.in), tc.n)); got != tc.want {
+			t.Errorf("tail(%q, %d) = %q; want %q", tc.in, tc.n, got, tc.want)
+		}
+	}
+}
+
+// TestProcessDeadline is not parallel, since it modifies the global opts.
+func TestProcessDeadline(t *testing.T) {
+	original := opts
+	defer func() { opts = original }()
+	opts.ScenarioTimeout = time.Millisecond
+	s := newSupervisor()
+	defer s.registerTest(t, Scenario{{Dimension: "dim1", Name: "dim1val1"}})()
+	startClock(t.Name(), true)
+	// Processes may be owned by a shared fixture which outlives t, so are
+	// not killed at its scenario deadline.
+	want, wantOK := t.Deadline()
+	if wantOK {
+		want = want.Add(-time.Second)
+	}
+	if got, ok := processDeadline(t); ok != wantOK || !got.Equal(want) {
+		t.Errorf("got deadline %s, %t; want %s, %t", got, ok, want, wantOK)
+	}
+}
@@ -0,0 +1,184 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package testmatrix
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// greeting is a dimension value which configures processes.
+type greeting string
+
+func (g greeting) ConfigureCommand(cmd *Command) {
+	cmd.Env = append(cmd.Env, "GREETING="+string(g))
+}
+
+func TestStartProcess(t *testing.T) {
+	t.Parallel()
+	m := New(Dim("greeting", "", Values{"hello": greeting("hello")}))
+	root := t.TempDir()
+	withArtifactsDir(m.sup, root)
+	var p *Process
+	var child int
+	runGroup(t, "run", func(t *testing.T) {
+		r := m.NewRunner(t)
+		r.Run("test", func(t *testing.T, s Scenario) Fixture {
+			p = StartProcess(t, Command{
+				Name: "greeter",
+				Path: "/bin/sh",
+				Args: []string{"-c", `sleep 30 & echo "child $!"; echo "$GREETING"; echo ready; wait`},
+			})
+			WaitReady(t, p.OutputProbe("^ready$"))
+			return p
+		}, func(t *testing.T, f Fixture) {
+			out := string(f.(*Process).Output())
+			if !strings.Contains(out, "hello\n") {
+				t.Errorf("got output %q; want it to contain hello", out)
+			}
+			fields := strings.Fields(out)
+			child, _ = strconv.Atoi(fields[1])
+		})
+	})
+	select {
+	case <-p.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("process still running after teardown")
+	}
+	// The child is in the same process group, so must have been killed too.
+	deadline := time.Now().Add(5 * time.Second)
+	for syscall.Kill(child, 0) == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("child process %d still running after teardown", child)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	log, err := os.ReadFile(p.logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(log), "hello\nready\n") {
+		t.Errorf("got log file %q; want it to contain the output", log)
+	}
+}
+
+func TestProcess_Stop_kill(t *testing.T) {
+	t.Parallel()
+	m := New(makeTestDim(1, 1))
+	withArtifactsDir(m.sup, t.TempDir())
+	runGroup(t, "run", func(t *testing.T) {
+		r := m.NewRunner(t)
+		r.Run("test", nil, func(t *testing.T, f Fixture) {
+			p := StartProcess(t, Command{
+				Path: "/bin/sh",
+				Args: []string{"-c", `trap "" TERM; echo ready; while :; do sleep 1; done`},
+			})
+			WaitReady(t, p.OutputProbe("^ready$"))
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if err := p.Stop(ctx); err == nil || !strings.Contains(err.Error(), "killed after not stopping in time") {
+				t.Errorf("got error %v; want it to be killed", err)
+			}
+		})
+	})
+}
+
+func TestProcess_kill_exited(t *testing.T) {
+	t.Parallel()
+	m := New(makeTestDim(1, 1))
+	withArtifactsDir(m.sup, t.TempDir())
+	var child int
+	runGroup(t, "run", func(t *testing.T) {
+		r := m.NewRunner(t)
+		r.Run("test", nil, func(t *testing.T, f Fixture) {
+			// The child ignores SIGTERM, so survives Stop, and does not hold
+			// the output open, so Stop returns once the leader has exited.
+			p := StartProcess(t, Command{
+				Path: "/bin/sh",
+				Args: []string{"-c", `(trap "" TERM; sleep 30 >/dev/null 2>&1) & echo "child $!"; echo ready; wait`},
+			})
+			WaitReady(t, p.OutputProbe("^ready$"))
+			child, _ = strconv.Atoi(strings.Fields(string(p.Output()))[1])
+			if err := p.Stop(context.Background()); err != nil {
+				t.Errorf("got error %v; want nil", err)
+			}
+			if syscall.Kill(child, 0) != nil {
+				t.Errorf("child process %d not running after Stop", child)
+			}
+		})
+	})
+	// The group is killed once the test is torn down, though its leader had
+	// already exited.
+	deadline := time.Now().Add(5 * time.Second)
+	for syscall.Kill(child, 0) == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("child process %d still running after teardown", child)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStartProcess_sandboxed(t *testing.T) {
+	t.Parallel()
+	m := New(makeTestDim(1, 1))
+	withArtifactsDir(m.sup, t.TempDir())
+	runGroup(t, "run", func(t *testing.T) {
+		r := m.NewRunner(t, Sandboxed())
+		r.Run("test", nil, func(t *testing.T, f Fixture) {
+			p := StartProcess(t, Command{
+				Path: "/bin/sh",
+				Args: []string{"-c", `echo "$HOME $XDG_CONFIG_HOME"; echo ready; sleep 30`},
+			})
+			WaitReady(t, p.OutputProbe("^ready$"))
+			sb := SandboxOf(t)
+			want := sb.Home + " " + sb.ConfigHome + "\n"
+			if got := string(p.Output()); !strings.HasPrefix(got, want) {
+				t.Errorf("got output %q; want it to start with %q", got, want)
+			}
+		})
+	})
+}
+
+// exitProcess starts a process for t which exits unexpectedly, and waits
+// for the exit to be reported.
+func exitProcess(t *testing.T) {
+	p := StartProcess(t, Command{Name: "quitter", Path: "/bin/sh", Args: []string{"-c", "echo bye; exit 3"}})
+	<-p.Done()
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestHelper_processExit(t *testing.T) {
+	helperTest(t)
+	m := New(makeTestDim(1, 1))
+	r := m.NewRunner(t)
+	r.Run("test", nil, func(t *testing.T, f Fixture) { exitProcess(t) })
+}
+
+func TestHelper_processExitSubtest(t *testing.T) {
+	helperTest(t)
+	m := New(makeTestDim(1, 1))
+	r := m.NewRunner(t)
+	r.Run("test", nil, func(t *testing.T, f Fixture) { t.Run("sub", exitProcess) })
+}
+
+func TestStartProcess_exit(t *testing.T) {
+	t.Parallel()
+	for _, name := range []string{"TestHelper_processExit", "TestHelper_processExitSubtest"} {
+		out := runHelperTest(t, name)
+		for _, want := range []string{
+			"quitter: bye",
+			"exited unexpectedly: exit status 3",
+			"--- FAIL: " + name + "/dim1val1/test",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("got output:\n%s\nwant it to contain %q", out, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package testmatrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// Probe checks whether part of a fixture is ready, for use with WaitReady.
+type Probe interface {
+	// Check returns nil if ready, or an error saying why not.
+	Check(ctx context.Context) error
+	// String describes what is being waited for.
+	String() string
+}
+
+// ProbeFunc returns a custom Probe described by desc, which is ready when
+// check returns nil.
+func ProbeFunc(desc string, check func(ctx context.Context) error) Probe {
+	return probeFunc{desc, check}
+}
+
+type probeFunc struct {
+	desc  string
+	check func(context.Context) error
+}
+
+func (p probeFunc) Check(ctx context.Context) error { return p.check(ctx) }
+func (p probeFunc) String() string                  { return p.desc }
+
+// TCPProbe is ready when addr accepts TCP connections.
+func TCPProbe(addr string) Probe {
+	return ProbeFunc("TCP "+addr, func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+// HTTPProbe is ready when a GET request to url returns status 200.
+func HTTPProbe(url string) Probe {
+	return ProbeFunc("HTTP "+url, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("got status %s", resp.Status)
+		}
+		return nil
+	})
+}
+
+// FileProbe is ready when path exists.
+func FileProbe(path string) Probe {
+	return ProbeFunc("file "+path, func(context.Context) error {
+		_, err := os.Stat(path)
+		return err
+	})
+}
+
+// LogFileProbe is ready when a line of the file at path matches pattern. It
+// panics if pattern is not a valid regular expression.
+func LogFileProbe(path, pattern string) Probe {
+	return MatchProbe("log "+path, regexp.MustCompile("(?m)"+pattern), func() ([]byte, error) {
+		return os.ReadFile(path)
+	})
+}
+
+// MatchProbe is ready when the output returned by read matches re. Use it to
+// wait for a log line from a source other than a file.
+func MatchProbe(desc string, re *regexp.Regexp, read func() ([]byte, error)) Probe {
+	return ProbeFunc(fmt.Sprintf("%s matching %q", desc, re), func(context.Context) error {
+		b, err := read()
+		if err != nil {
+			return err
+		}
+		if !re.Match(b) {
+			return errors.New("no match yet")
+		}
+		return nil
+	})
+}
+
+// Backoff between probe attempts.
+const (
+	minProbeInterval = 10 * time.Millisecond
+	maxProbeInterval = time.Second
+)
+
+// defaultReadyTimeout is how long WaitReady waits if neither a scenario
+// timeout nor a test deadline is set.
+const defaultReadyTimeout = time.Minute
+
+// WaitReady waits until probe is ready, retrying with exponential backoff.
+// It waits until the deadline of t's scenario (see Opts.ScenarioTimeout), or
+// failing that the deadline of the test binary, or one minute. If probe is
+// not ready in time, t fails with the last error from probe. It stops early
+// if the context of t is done; see Context.
+//
+// t must be a test started by Runner.Run or Runner.RunE, or a subtest of
+// one, so WaitReady can be called from fixture factories.
+func WaitReady(t *testing.T, probe Probe) {
+	t.Helper()
+	if err := WaitReadyE(t, probe); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// WaitReadyE is like WaitReady, but returns an error wrapping
+// ErrInfrastructure instead of failing t, for use in a FixtureFactoryE.
+func WaitReadyE(t *testing.T, probe Probe) error {
+	ctx, cancel := readyContext(t)
+	defer cancel()
+	c := scenarioOf(t)
+	start := time.Now()
+	err := waitReady(ctx, probe)
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %s not ready in scenario %s after %s: %s",
+		ErrInfrastructure, probe, c, time.Since(start).Round(time.Millisecond), err)
+}
+
+// waitReady checks probe until it is ready or ctx is done, backing off
+// exponentially between attempts. It returns the last error from probe if
+// ctx is done first.
+func waitReady(ctx context.Context, probe Probe) error {
+	interval := minProbeInterval
+	for {
+		err := probe.Check(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > maxProbeInterval {
+			interval = maxProbeInterval
+		}
+	}
+}
+
+// readyContext returns a context for waiting in t, with the deadline
+// described by WaitReady, derived from the context of t.
+func readyContext(t *testing.T) (context.Context, context.CancelFunc) {
+	parent := Context(t)
+	deadline, ok := scenarioDeadline(t.Name())
+	if !ok {
+		deadline, ok = t.Deadline()
+	}
+	if !ok {
+		deadline = time.Now().Add(defaultReadyTimeout)
+	}
+	return context.WithDeadline(parent, deadline)
+}
+
+// scenarioOf returns the scenario of t, if it is a test started by a Runner.
+func scenarioOf(t *testing.T) Scenario {
+	if _, rt, err := lookupRunningTest(t.Name()); err == nil {
+		return rt.scenario
+	}
+	return nil
+}
+
+// scenarioTimeout returns the configured scenario timeout, or 0 for none.
+func scenarioTimeout() time.Duration {
+	if *scenarioTimeoutFlag != 0 {
+		return *scenarioTimeoutFlag
+	}
+	return opts.ScenarioTimeout
+}
+
+// scenarioDeadline returns the deadline of the named test, if a scenario
+// timeout is set and its scenario clock has started. See startClock.
+func scenarioDeadline(name string) (time.Time, bool) {
+	timeout := scenarioTimeout()
+	if timeout == 0 {
+		return time.Time{}, false
+	}
+	_, rt, err := lookupRunningTest(name)
+	if err != nil {
+		return time.Time{}, false
+	}
+	runningTestsMu.Lock()
+	defer runningTestsMu.Unlock()
+	if rt.start.IsZero() {
+		return time.Time{}, false
+	}
+	return rt.start.Add(timeout), true
+}
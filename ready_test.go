@@ -0,0 +1,107 @@
+package testmatrix
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitReady_probes(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ready")
+	log := filepath.Join(dir, "log")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		os.WriteFile(file, nil, 0644)
+		os.WriteFile(log, []byte("starting\nlistening on :80\n"), 0644)
+	}()
+	var calls int32
+	cases := []Probe{
+		TCPProbe(l.Addr().String()),
+		HTTPProbe(srv.URL),
+		FileProbe(file),
+		LogFileProbe(log, "^listening on"),
+		ProbeFunc("custom", func(context.Context) error {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				return errors.New("not yet")
+			}
+			return nil
+		}),
+	}
+	for _, p := range cases {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := waitReady(ctx, p); err != nil {
+			t.Errorf("%s: %s", p, err)
+		}
+		cancel()
+	}
+}
+
+func TestWaitReadyE_interrupted(t *testing.T) {
+	t.Parallel()
+	s := newSupervisor()
+	s.interrupt()
+	c := Scenario{{Dimension: "dim1", Name: "dim1val1"}}
+	defer s.registerTest(t, c)()
+	err := WaitReadyE(t, ProbeFunc("thing", func(context.Context) error {
+		return errors.New("connection refused")
+	}))
+	if !errors.Is(err, ErrInfrastructure) {
+		t.Errorf("got error %v; want it to wrap ErrInfrastructure", err)
+	}
+	for _, want := range []string{"thing not ready in scenario dim1val1", "connection refused"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v; want it to contain %q", err, want)
+		}
+	}
+}
+
+// TestWaitReadyE_deadline is not parallel, since it modifies the global opts.
+func TestWaitReadyE_deadline(t *testing.T) {
+	original := opts
+	defer func() { opts = original }()
+	opts.ScenarioTimeout = 200 * time.Millisecond
+	s := newSupervisor()
+	c := Scenario{{Dimension: "dim1", Name: "dim1val1"}}
+	defer s.registerTest(t, c)()
+	// Time spent queued before the test is released does not count.
+	time.Sleep(300 * time.Millisecond)
+	startClock(t.Name(), true)
+	start := time.Now()
+	var checks int32
+	err := WaitReadyE(t, ProbeFunc("thing", func(context.Context) error {
+		atomic.AddInt32(&checks, 1)
+		return errors.New("connection refused")
+	}))
+	elapsed := time.Since(start)
+	if want := "thing not ready in scenario dim1val1"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v; want it to contain %q", err, want)
+	}
+	if elapsed < 150*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("gave up after %s; want about 200ms", elapsed)
+	}
+	if n := atomic.LoadInt32(&checks); n < 2 {
+		t.Errorf("got %d checks; want the probe retried until the deadline", n)
+	}
+}
@@ -0,0 +1,159 @@
+package testmatrix
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runningTests holds the tests currently running under any Runner, by test
+// name, so that package-level funcs such as ArtifactDir and Addrs can find the
+// scenario and supervisor of a test from its *testing.T.
+var (
+	runningTests   = map[string]*runningTest{}
+	runningTestsMu sync.Mutex
+)
+
+// runningTest is a test registered in runningTests.
+type runningTest struct {
+	t        *testing.T
+	sup      *supervisor
+	scenario Scenario
+	// start is when the scenario clock of the test started, from which its
+	// scenario deadline is measured, or zero if it has not started. See
+	// startClock. It is guarded by runningTestsMu.
+	start time.Time
+	// released is true once the test has been released to run.
+	// It is guarded by runningTestsMu.
+	released bool
+	// owner identifies the fixture being built by the test, which owns any
+	// addresses allocated and processes started while building it. It is
+	// the test name, except while building a shared fixture. It is guarded
+	// by runningTestsMu.
+	owner string
+	// sandbox is the sandbox of the test's fixture, if it has one. It is
+	// guarded by runningTestsMu.
+	sandbox *Sandbox
+	// ctx is the test's context, returned by Context. It is guarded by
+	// runningTestsMu.
+	ctx context.Context
+}
+
+// registerTest registers t as running in scenario c. The returned func must
+// be called once it has finished.
+func (s *supervisor) registerTest(t *testing.T, c Scenario) func() {
+	name := t.Name()
+	runningTestsMu.Lock()
+	defer runningTestsMu.Unlock()
+	runningTests[name] = &runningTest{t: t, sup: s, scenario: c, owner: name}
+	return func() {
+		runningTestsMu.Lock()
+		defer runningTestsMu.Unlock()
+		delete(runningTests, name)
+	}
+}
+
+// lookupRunningTest returns the registered test called name, or the test
+// that it is a subtest of, and its name.
+func lookupRunningTest(name string) (string, *runningTest, error) {
+	runningTestsMu.Lock()
+	defer runningTestsMu.Unlock()
+	return lookupRunningTestLocked(name)
+}
+
+// lookupRunningTestLocked is lookupRunningTest for callers holding
+// runningTestsMu.
+func lookupRunningTestLocked(name string) (string, *runningTest, error) {
+	for key := name; ; {
+		if rt, ok := runningTests[key]; ok {
+			return key, rt, nil
+		}
+		i := strings.LastIndex(key, "/")
+		if i == -1 {
+			return "", nil, fmt.Errorf("%s is not a test started by testmatrix", name)
+		}
+		key = key[:i]
+	}
+}
+
+// startClock starts the scenario clock of the test called name. The clock
+// of a test is started once it has been released to run and holds its
+// parallelism slots and resources, so that time spent queued does not count
+// against its scenario timeout. A fixture built before then, by Prefetch or
+// before a parallel test is released, is given its own scenario timeout,
+// from when the build starts. Builds after release do not restart the clock.
+func startClock(name string, released bool) {
+	runningTestsMu.Lock()
+	defer runningTestsMu.Unlock()
+	rt, ok := runningTests[name]
+	if !ok || rt.released {
+		return
+	}
+	rt.start = time.Now()
+	rt.released = released
+}
+
+// setOwner sets the owner of the fixture being built by the test called
+// name, and returns a func restoring the previous owner.
+func setOwner(name, owner string) func() {
+	runningTestsMu.Lock()
+	defer runningTestsMu.Unlock()
+	rt, ok := runningTests[name]
+	if !ok {
+		return func() {}
+	}
+	previous := rt.owner
+	rt.owner = owner
+	return func() {
+		runningTestsMu.Lock()
+		defer runningTestsMu.Unlock()
+		rt.owner = previous
+	}
+}
+
+// ownerOf returns the owner of the fixture being built by rt.
+func ownerOf(rt *runningTest) string {
+	runningTestsMu.Lock()
+	defer runningTestsMu.Unlock()
+	return rt.owner
+}
+
+// whileRunning calls f with the registered test called name, or the test
+// that it is a subtest of, if that test is still running, and returns whether
+// it did. The test cannot finish while f is running, so f may safely call
+// t.Log or t.Error from another goroutine, even if the subtest called name
+// has finished. f must not use runningTests.
+func whileRunning(name string, f func(t *testing.T)) bool {
+	runningTestsMu.Lock()
+	defer runningTestsMu.Unlock()
+	_, rt, err := lookupRunningTestLocked(name)
+	if err != nil {
+		return false
+	}
+	f(rt.t)
+	return true
+}
+
+// onRelease registers f to be called when owner is released.
+func (s *supervisor) onRelease(owner string, f func()) {
+	s.ownedMu.Lock()
+	defer s.ownedMu.Unlock()
+	s.owned[owner] = append(s.owned[owner], f)
+}
+
+// releaseOwner releases the addresses held by owner, and calls the funcs
+// registered using onRelease for it, most recently registered first. Owners
+// are released once their fixture has been torn down.
+func (s *supervisor) releaseOwner(owner string) {
+	s.addrs.release(owner)
+	s.ownedMu.Lock()
+	funcs := s.owned[owner]
+	delete(s.owned, owner)
+	s.ownedMu.Unlock()
+	for i := len(funcs) - 1; i >= 0; i-- {
+		funcs[i]()
+	}
+}
@@ -0,0 +1,234 @@
+package testmatrix
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Resource returns a new Matrix based on m with a named resource, such as a
+// heavyweight local service, of which tests can consume at most capacity at
+// once. Tests state what they consume using ResourceConsumer values or the
+// Consumes RunOption. It panics if capacity is less than 1.
+//
+// Resources are shared by all matrices derived from the same call to New, so
+// tests fail if they declare the same resource with different capacities.
+func (m Matrix) Resource(name string, capacity int) Matrix {
+	if capacity < 1 {
+		panic(fmt.Sprintf("resource %q: capacity must be at least 1; got %d", name, capacity))
+	}
+	resources := make(map[string]int, len(m.resources)+1)
+	for n, c := range m.resources {
+		resources[n] = c
+	}
+	resources[name] = capacity
+	m.resources = resources
+	return m
+}
+
+// ResourceConsumer is implemented by dimension values whose tests consume
+// resources declared using Matrix.Resource. Consumes returns the weight of
+// each resource consumed, by name.
+type ResourceConsumer interface {
+	Consumes() map[string]int
+}
+
+// Consumes makes each test consume weight of the named resource, declared
+// using Matrix.Resource, in addition to any consumed by its scenario's values.
+// It panics if weight is less than 1.
+func Consumes(name string, weight int) RunOption {
+	if weight < 1 {
+		panic(fmt.Sprintf("resource %q: weight must be at least 1; got %d", name, weight))
+	}
+	return func(o *runOptions) {
+		o.consumes = append(o.consumes[:len(o.consumes):len(o.consumes)], resourceClaim{name, weight})
+	}
+}
+
+// resourceClaim is an amount of a resource consumed by a test.
+type resourceClaim struct {
+	name   string
+	weight int
+}
+
+// consumption returns the resources consumed by tests in scenario c run with
+// options o, sorted by name, with the weights of each resource summed.
+func consumption(c Scenario, o runOptions) []resourceClaim {
+	weights := map[string]int{}
+	for _, b := range c {
+		if rc, ok := b.Value.(ResourceConsumer); ok {
+			for name, w := range rc.Consumes() {
+				weights[name] += w
+			}
+		}
+	}
+	for _, rc := range o.consumes {
+		weights[rc.name] += rc.weight
+	}
+	claims := make([]resourceClaim, 0, len(weights))
+	for name, w := range weights {
+		claims = append(claims, resourceClaim{name, w})
+	}
+	sort.Slice(claims, func(i, j int) bool { return claims[i].name < claims[j].name })
+	return claims
+}
+
+// acquireResources acquires claims for t, in name order so that tests
+// acquiring several resources cannot deadlock. It fails t if a resource is
+// not declared or the claim exceeds its capacity, and skips t if the run is
+// interrupted while waiting. The returned func releases the resources.
+func (pf *Runner) acquireResources(t *testing.T, claims []resourceClaim) func() {
+	t.Helper()
+	var acquired []func()
+	release := func() {
+		for i := len(acquired) - 1; i >= 0; i-- {
+			acquired[i]()
+		}
+	}
+	var waited []string
+	for _, rc := range claims {
+		capacity, ok := pf.matrix.resources[rc.name]
+		if !ok {
+			release()
+			t.Fatalf("unknown resource %q; declare it using Matrix.Resource", rc.name)
+		}
+		if rc.weight > capacity {
+			release()
+			t.Fatalf("consuming %d of resource %q, which has capacity %d", rc.weight, rc.name, capacity)
+		}
+		pool, err := pf.parent.resourcePool(rc.name, capacity)
+		if err != nil {
+			release()
+			t.Fatal(err)
+		}
+		start := time.Now()
+		if err := pool.acquire(pf.parent.ctx, rc.weight); err != nil {
+			release()
+			pf.skipIfInterrupted(t)
+			t.Fatalf("acquiring resource %q: %s", rc.name, err)
+		}
+		wait := time.Since(start)
+		pool.recordWait(wait)
+		if wait >= time.Millisecond {
+			waited = append(waited, fmt.Sprintf("%s %s", rc.name, wait.Round(time.Millisecond)))
+		}
+		pool, weight := pool, rc.weight
+		acquired = append(acquired, func() { pool.release(weight) })
+	}
+	if len(waited) != 0 {
+		t.Logf("waited for resources: %s", strings.Join(waited, ", "))
+	}
+	return release
+}
+
+// resourcePool is a weighted semaphore limiting the use of a resource.
+// Waiters are granted the resource in the order they asked for it.
+type resourcePool struct {
+	name     string
+	capacity int
+	mu       sync.Mutex
+	used     int
+	waiters  []*resourceWaiter
+	// acquisitions, totalWait and maxWait are reported in the summary.
+	acquisitions int
+	totalWait    time.Duration
+	maxWait      time.Duration
+}
+
+type resourceWaiter struct {
+	weight int
+	ready  chan struct{}
+}
+
+// resourcePool returns the pool for the named resource, creating it with
+// capacity if necessary. It returns an error if the pool already exists with
+// a different capacity.
+func (s *supervisor) resourcePool(name string, capacity int) (*resourcePool, error) {
+	s.resourcesMu.Lock()
+	defer s.resourcesMu.Unlock()
+	pool, ok := s.resources[name]
+	if !ok {
+		pool = &resourcePool{name: name, capacity: capacity}
+		s.resources[name] = pool
+	}
+	if pool.capacity != capacity {
+		return nil, fmt.Errorf("resource %q declared with capacity %d, but already has capacity %d", name, capacity, pool.capacity)
+	}
+	return pool, nil
+}
+
+// acquire waits until weight of the resource is available and takes it, or
+// returns ctx.Err() if ctx is done first.
+func (p *resourcePool) acquire(ctx context.Context, weight int) error {
+	p.mu.Lock()
+	if len(p.waiters) == 0 && p.used+weight <= p.capacity {
+		p.used += weight
+		p.mu.Unlock()
+		return nil
+	}
+	w := &resourceWaiter{weight: weight, ready: make(chan struct{})}
+	p.waiters = append(p.waiters, w)
+	p.mu.Unlock()
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+	p.mu.Lock()
+	for i, o := range p.waiters {
+		if o == w {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			p.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	p.mu.Unlock()
+	// The resource was granted after ctx was done, so give it back.
+	p.release(weight)
+	return ctx.Err()
+}
+
+// release returns weight of the resource, and grants it to waiters in turn
+// for as long as the first waiter's weight is available.
+func (p *resourcePool) release(weight int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.used -= weight
+	for len(p.waiters) != 0 && p.used+p.waiters[0].weight <= p.capacity {
+		w := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		p.used += w.weight
+		close(w.ready)
+	}
+}
+
+func (p *resourcePool) recordWait(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.acquisitions++
+	p.totalWait += d
+	if d > p.maxWait {
+		p.maxWait = d
+	}
+}
+
+// resourceSlice returns a description of the use of each resource, sorted by
+// name.
+func (s *supervisor) resourceSlice() []string {
+	s.resourcesMu.Lock()
+	defer s.resourcesMu.Unlock()
+	var r []string
+	for _, p := range s.resources {
+		p.mu.Lock()
+		r = append(r, fmt.Sprintf("%s (capacity %d): %d acquisitions; waited %s in total, %s at most",
+			p.name, p.capacity, p.acquisitions,
+			p.totalWait.Round(time.Millisecond), p.maxWait.Round(time.Millisecond)))
+		p.mu.Unlock()
+	}
+	sort.Strings(r)
+	return r
+}
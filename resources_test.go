@@ -0,0 +1,130 @@
+package testmatrix
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type dbValue string
+
+func (dbValue) Consumes() map[string]int { return map[string]int{"db": 1} }
+
+func TestConsumption(t *testing.T) {
+	t.Parallel()
+	c := Scenario{
+		{Dimension: "a", Name: "x", Value: dbValue("x")},
+		{Dimension: "b", Name: "y", Value: "y"},
+	}
+	o := newRunOptions([]RunOption{Consumes("net", 1), Consumes("db", 2)})
+	got := consumption(c, o)
+	want := []resourceClaim{{"db", 3}, {"net", 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestMatrix_Resource(t *testing.T) {
+	t.Parallel()
+	m := New(makeTestDim(1, 1)).Resource("db", 1)
+	n := m.Resource("db", 2).Resource("net", 3)
+	if got := m.resources; !reflect.DeepEqual(got, map[string]int{"db": 1}) {
+		t.Errorf("original matrix resources changed to %v", got)
+	}
+	if got := n.resources; !reflect.DeepEqual(got, map[string]int{"db": 2, "net": 3}) {
+		t.Errorf("got resources %v", got)
+	}
+}
+
+func TestSupervisor_resourcePool(t *testing.T) {
+	t.Parallel()
+	s := newSupervisor()
+	p, err := s.resourcePool("db", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q, err := s.resourcePool("db", 2); q != p || err != nil {
+		t.Errorf("got pool %p, error %v; want %p, nil", q, err, p)
+	}
+	want := `resource "db" declared with capacity 3, but already has capacity 2`
+	if _, err := s.resourcePool("db", 3); err == nil || err.Error() != want {
+		t.Errorf("got error %v; want %q", err, want)
+	}
+}
+
+// acquired returns true if acquiring finishes within a short time.
+func acquired(done <-chan error) bool {
+	select {
+	case <-done:
+		return true
+	case <-time.After(20 * time.Millisecond):
+		return false
+	}
+}
+
+func TestResourcePool(t *testing.T) {
+	t.Parallel()
+	p := &resourcePool{name: "db", capacity: 2}
+	ctx := context.Background()
+	if err := p.acquire(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	heavy, light := make(chan error, 1), make(chan error, 1)
+	go func() { heavy <- p.acquire(ctx, 2) }()
+	if acquired(heavy) {
+		t.Fatalf("acquired weight 2 with only 1 free")
+	}
+	go func() { light <- p.acquire(ctx, 1) }()
+	if acquired(light) {
+		t.Fatalf("acquired weight 1 ahead of an earlier waiter")
+	}
+	p.release(1)
+	if !acquired(heavy) {
+		t.Fatalf("did not acquire weight 2 once free")
+	}
+	p.release(2)
+	if !acquired(light) {
+		t.Fatalf("did not acquire weight 1 once free")
+	}
+}
+
+func TestResourcePool_cancel(t *testing.T) {
+	t.Parallel()
+	p := &resourcePool{name: "db", capacity: 1}
+	if err := p.acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.acquire(ctx, 1); err != context.Canceled {
+		t.Errorf("got error %v; want %v", err, context.Canceled)
+	}
+	if len(p.waiters) != 0 {
+		t.Errorf("got %d waiters after cancelling; want 0", len(p.waiters))
+	}
+}
+
+func TestRunner_Run_resources(t *testing.T) {
+	t.Parallel()
+	values := Values{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		values[name] = dbValue(name)
+	}
+	m := New(Dim("db", "", values)).Resource("db", 2).Resource("net", 2)
+	var current, max int32
+	runGroup(t, "run", func(t *testing.T) {
+		r := m.NewRunner(t)
+		r.Run("test", nil, concurrencyTest(&current, &max))
+		r.Run("heavy", nil, concurrencyTest(&current, &max), Consumes("net", 2))
+	})
+	if max > 2 {
+		t.Errorf("got %d tests at once; want at most 2", max)
+	}
+	got := m.sup.resourceSlice()
+	if len(got) != 2 || !strings.HasPrefix(got[0], "db (capacity 2): 12 acquisitions;") ||
+		!strings.HasPrefix(got[1], "net (capacity 2): 6 acquisitions;") {
+		t.Errorf("got resource summary %q", got)
+	}
+}
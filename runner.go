@@ -1,11 +1,11 @@
 package testmatrix
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"sync"
 	"testing"
-	"time"
 )
 
 // Runner runs tests defined in a Matrix.
@@ -20,7 +20,34 @@ type Runner struct {
 	testNamesSkippedMu sync.Mutex
 	testNamesFailed    map[string]struct{}
 	testNamesFailedMu  sync.Mutex
-	parent             *supervisor
+	// testNamesFailedIn maps names of tests which failed in a hook to the
+	// name of that hook.
+	testNamesFailedIn   map[string]string
+	testNamesFailedInMu sync.Mutex
+	// testNamesInfra maps names of tests which failed with an
+	// infrastructure error to that error.
+	testNamesInfra   map[string]string
+	testNamesInfraMu sync.Mutex
+	// testNamesInterrupted holds names of tests not run because the run was
+	// interrupted.
+	testNamesInterrupted   map[string]struct{}
+	testNamesInterruptedMu sync.Mutex
+	// teardownFailures maps names of tests whose teardown failed or timed out
+	// to a description of the failure.
+	teardownFailures   map[string]string
+	teardownFailuresMu sync.Mutex
+	// keptFixtures maps names of failed tests whose fixtures were kept to a
+	// description of the fixture.
+	keptFixtures   map[string]string
+	keptFixturesMu sync.Mutex
+	// options are the RunOptions passed to NewRunner, which apply to every
+	// call to Run.
+	options runOptions
+	// slots limits the number of tests running at once, if parallelism
+	// with a maximum was passed to NewRunner.
+	slots     semaphore
+	slotsOnce sync.Once
+	parent    *supervisor
 }
 
 func (pf *Runner) recordTestStarted(t *testing.T) {
@@ -44,52 +71,137 @@ type FixtureFactory func(*testing.T, Scenario) Fixture
 type Fixture interface{}
 
 // TearableDown is a kind of Fixture that can be torn down after a test has
-// finished.
+// finished. Teardown may call t.Fatal. If it does not return by the teardown
+// deadline, the test fails and Teardown is left running in the background, so
+// it must not use t after the deadline. See also ContextTearableDown.
 type TearableDown interface {
 	Teardown(*testing.T)
 }
 
-func (pf *Runner) teardown(t *testing.T, f Fixture) {
-	if tear, ok := f.(TearableDown); ok {
-		tear.Teardown(t)
-	}
-}
-
 // Run is analogous to *testing.T.Run, but takes a method makeFixture that
 // generates a fixture from the test and scenario, and passes that to the
 // test func along with the *testing.T.
-func (pf *Runner) Run(name string, makeFixture FixtureFactory, test Test) {
-	for _, c := range pf.matrix.scenarios() {
-		c := c
-		pf.t.Run(c.String()+"/"+name, func(t *testing.T) {
-			pf.recordTestStarted(t)
-			defer pf.recordTestStatus(t)
-			pf.parent.wg.Add(1)
-			fix := makeFixture(t, c)
-			defer func() {
-				// TODO: Make timeout configurable.
-				timeout := 10 * time.Second
-				defer pf.parent.wg.Done()
-				select {
-				case <-time.After(timeout):
-					rtLog("ERROR: Teardown took longer than %s", timeout)
-				case <-func() <-chan struct{} {
-					c := make(chan struct{})
-					go func() {
-						pf.teardown(t, fix)
-						close(c)
-					}()
-					return c
-				}():
+//
+// If makeFixture is nil, ComposeE is used to assemble the fixture from the
+// scenario's values.
+//
+// By default each test gets its own fixture; pass Shared or SharedKey to
+// share fixtures between tests in the same scenario. Tests run in parallel
+// unless configured otherwise using WithParallelism.
+func (pf *Runner) Run(name string, makeFixture FixtureFactory, test Test, options ...RunOption) {
+	var makeFixtureE FixtureFactoryE
+	if makeFixture != nil {
+		makeFixtureE = fixtureFactoryE(makeFixture)
+	}
+	noPrefetch := func(o *runOptions) { o.noPrefetch = true }
+	pf.RunE(name, makeFixtureE, test, append([]RunOption{noPrefetch}, options...)...)
+}
+
+// RunE is like Run, but takes a FixtureFactoryE, which can classify errors
+// creating fixtures as ErrUnsupported or ErrInfrastructure.
+//
+// With either Run or RunE, a panic in the fixture factory is reported as an
+// infrastructure error.
+func (pf *Runner) RunE(name string, makeFixture FixtureFactoryE, test Test, options ...RunOption) {
+	o := pf.options.with(options)
+	o.parallelism, o.sem = pf.parallelism(newRunOptions(options))
+	if makeFixture == nil {
+		makeFixture = ComposeE
+	}
+	scenarios := pf.matrix.scenarios()
+	p := newPrefetcher(prefetchCount(o), len(scenarios))
+	defer p.close()
+	run := func(parent T, c Scenario, path string) {
+		parent.Run(path, func(t *testing.T) {
+			pf.runTest(t, c, makeFixture, test, o, p)
+		})
+	}
+	for _, g := range pf.matrix.groupScenarios(scenarios) {
+		if g.name == "" {
+			pf.hold(pf.t, g.scenarios, o)
+			for _, c := range g.scenarios {
+				run(pf.t, c, c.String()+"/"+name)
+			}
+			continue
+		}
+		// Run each group as a single non-parallel sub-test, so that the
+		// next group only starts once all of its tests have finished.
+		g := g
+		pf.t.Run(g.name, func(t *testing.T) {
+			pf.hold(t, g.scenarios, o)
+			for _, c := range g.scenarios {
+				path := name
+				if _, rest := pf.matrix.splitSticky(c); len(rest) != 0 {
+					path = rest.String() + "/" + name
 				}
-			}()
-			// TODO: Make parallel configurable.
-			t.Parallel()
-			test(t, fix)
+				run(t, c, path)
+			}
 		})
 	}
 }
 
+// runTest runs a single test in scenario c.
+// If p is not nil, the fixture may be built in the background by p.
+func (pf *Runner) runTest(t *testing.T, c Scenario, makeFixture FixtureFactoryE, test Test, o runOptions, p *prefetcher) {
+	pf.recordTestStarted(t)
+	defer pf.recordTestStatus(t)
+	defer pf.parent.registerTest(t, c)()
+	defer pf.parent.startTestContext(t, c)()
+	// Anything the fixture uses is only released once its teardown has
+	// really finished, even if it did not finish in time.
+	tr := &teardownTracker{}
+	defer tr.whenDone(func() { pf.releaseOwned(t) })
+	pf.skipIfInterrupted(t)
+	values := pf.parent.retainValues(c)
+	defer pf.releaseValues(t, values)
+	if err := pf.parent.setUpValues(values); err != nil {
+		pf.handleFixtureError(t, c, err)
+	}
+	ss := pf.parent.retainScenario(c)
+	defer pf.endScenario(t, c, ss)
+	pf.startScenario(t, c, ss)
+	build := func() (Fixture, func(context.Context, *testing.T, bool) error, error) {
+		labelGoroutine(t)
+		startClock(t.Name(), false)
+		return pf.makeFixture(t, c, makeFixture, o)
+	}
+	// Tests consuming resources only build their fixtures once released
+	// and holding the resources, since a test holding resources while
+	// waiting to be released could block the tests before it.
+	claims := consumption(c, o)
+	var job *prefetchJob
+	if len(claims) == 0 {
+		job = p.submit(build)
+		if p == nil {
+			// Without prefetching, build the fixture before the test is
+			// released.
+			job.take()
+		}
+	}
+	startParallel(t, o.parallelism)
+	o.sem.acquire()
+	defer o.sem.release()
+	if job == nil {
+		defer tr.whenDone(pf.acquireResources(t, claims))
+		job = newPrefetchJob(build)
+	}
+	defer startDeadline(t, c)()
+	fix, teardown, err := job.take()
+	if err != nil {
+		pf.handleFixtureError(t, c, err)
+	}
+	defer pf.finishFixture(t, c, fix, tr, teardown)
+	pf.skipIfInterrupted(t)
+	if opts.AfterEach != nil {
+		defer pf.runHook(t, phaseAfterEach, func() { opts.AfterEach(t, c, fix) })
+	}
+	defer pf.diagnose(t, c, fix)
+	if opts.BeforeEach != nil {
+		pf.runHook(t, phaseBeforeEach, func() { opts.BeforeEach(t, c, fix) })
+	}
+	test(t, fix)
+}
+
 func (pf *Runner) recordTestStatus(t *testing.T) {
 	t.Helper()
 	name := t.Name()
@@ -113,6 +225,13 @@ func (pf *Runner) recordTestStatus(t *testing.T) {
 		pf.testNamesPassed[name] = struct{}{}
 		pf.testNamesPassedMu.Unlock()
 		return
+	case pf.wasInterrupted(name):
+		*status = "INTERRUPTED"
+		return
+	case pf.wasInfraError(name):
+		*status = "INFRASTRUCTURE ERROR"
+		pf.parent.testFailed(name)
+		return
 	case t.Skipped():
 		*status = "SKIPPED"
 		pf.testNamesSkippedMu.Lock()
@@ -124,10 +243,42 @@ func (pf *Runner) recordTestStatus(t *testing.T) {
 		pf.testNamesFailedMu.Lock()
 		pf.testNamesFailed[name] = struct{}{}
 		pf.testNamesFailedMu.Unlock()
+		pf.parent.testFailed(name)
 		return
 	}
 }
 
+// testStatus returns the status of the named test, as recorded by
+// recordTestStatus, and whether it was run by pf.
+func (pf *Runner) testStatus(name string) (string, bool) {
+	pf.testNamesMu.RLock()
+	_, started := pf.testNames[name]
+	pf.testNamesMu.RUnlock()
+	if !started {
+		return "", false
+	}
+	switch {
+	case pf.wasInterrupted(name):
+		return "INTERRUPTED", true
+	case pf.wasInfraError(name):
+		return "INFRASTRUCTURE ERROR", true
+	case hasTestName(&pf.testNamesPassedMu, pf.testNamesPassed, name):
+		return "PASSED", true
+	case hasTestName(&pf.testNamesSkippedMu, pf.testNamesSkipped, name):
+		return "SKIPPED", true
+	case hasTestName(&pf.testNamesFailedMu, pf.testNamesFailed, name):
+		return "FAILED", true
+	}
+	return "MISSING", true
+}
+
+func hasTestName(mu *sync.Mutex, m map[string]struct{}, name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	_, ok := m[name]
+	return ok
+}
+
 func testNamesSlice(m map[string]struct{}) []string {
 	var s, i = make([]string, len(m)), 0
 	for n := range m {
@@ -141,15 +292,25 @@ func rtLog(format string, a ...interface{}) {
 	fmt.Fprintf(os.Stderr, format+"\n", a...)
 }
 
-func (pf *Runner) summary() (total, passed, skipped, failed, missing []string) {
+func (pf *Runner) summary() (total, passed, skipped, failed, infra, interrupted, missing []string) {
 	t := pf.t
 	t.Helper()
 	total = testNamesSlice(pf.testNames)
 	passed = testNamesSlice(pf.testNamesPassed)
 	skipped = testNamesSlice(pf.testNamesSkipped)
 	failed = testNamesSlice(pf.testNamesFailed)
+	pf.testNamesFailedInMu.Lock()
+	for i, name := range failed {
+		if phase, ok := pf.testNamesFailedIn[name]; ok {
+			failed[i] = fmt.Sprintf("%s (in %s hook)", name, phase)
+		}
+	}
+	pf.testNamesFailedInMu.Unlock()
 
-	missingCount := len(total) - (len(passed) + len(failed) + len(skipped))
+	infra = pf.infraSlice()
+	interrupted = pf.interruptedSlice()
+
+	missingCount := len(total) - (len(passed) + len(failed) + len(skipped) + len(infra) + len(interrupted))
 	if missingCount != 0 {
 		for t := range pf.testNamesPassed {
 			delete(pf.testNames, t)
@@ -160,9 +321,17 @@ func (pf *Runner) summary() (total, passed, skipped, failed, missing []string) {
 		for t := range pf.testNamesFailed {
 			delete(pf.testNames, t)
 		}
+		pf.testNamesInfraMu.Lock()
+		for t := range pf.testNamesInfra {
+			delete(pf.testNames, t)
+		}
+		pf.testNamesInfraMu.Unlock()
+		for _, t := range interrupted {
+			delete(pf.testNames, t)
+		}
 		for t := range pf.testNames {
 			missing = append(missing, t)
 		}
 	}
-	return total, passed, skipped, failed, missing
+	return total, passed, skipped, failed, infra, interrupted, missing
 }
@@ -0,0 +1,82 @@
+package testmatrix
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testFixture records how many times it has been torn down.
+type testFixture struct {
+	scenario  Scenario
+	mu        sync.Mutex
+	teardowns int
+}
+
+func (f *testFixture) Teardown(*testing.T) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.teardowns++
+}
+
+// testFixtureFactory returns a FixtureFactory which records every fixture it
+// creates in fixtures.
+func testFixtureFactory(mu *sync.Mutex, fixtures *[]*testFixture) FixtureFactory {
+	return func(t *testing.T, s Scenario) Fixture {
+		f := &testFixture{scenario: s}
+		mu.Lock()
+		defer mu.Unlock()
+		*fixtures = append(*fixtures, f)
+		return f
+	}
+}
+
+// runGroup runs f as a subtest, and only returns once f and all its
+// parallel subtests have finished.
+func runGroup(t *testing.T, name string, f func(t *testing.T)) {
+	t.Helper()
+	t.Run("group", func(t *testing.T) {
+		t.Run(name, f)
+	})
+}
+
+func TestRunner_Run_shared(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name         string
+		options      []RunOption
+		wantFixtures int
+	}{
+		{"unshared", nil, 4},
+		{"shared", []RunOption{Shared()}, 2},
+		{"sharedkey", []RunOption{SharedKey("k")}, 2},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			m := New(makeTestDim(1, 2))
+			var mu sync.Mutex
+			var fixtures []*testFixture
+			factory := testFixtureFactory(&mu, &fixtures)
+			runGroup(t, "run", func(t *testing.T) {
+				r := m.NewRunner(t)
+				for _, name := range []string{"one", "two"} {
+					r.Run(name, factory, func(t *testing.T, f Fixture) {
+						if got := f.(*testFixture).scenario.String(); !strings.Contains(t.Name(), "/"+got+"/") {
+							t.Errorf("got fixture for scenario %q in test %q", got, t.Name())
+						}
+					}, tc.options...)
+				}
+			})
+			if len(fixtures) != tc.wantFixtures {
+				t.Errorf("got %d fixtures; want %d", len(fixtures), tc.wantFixtures)
+			}
+			for _, f := range fixtures {
+				if f.teardowns != 1 {
+					t.Errorf("fixture for %s torn down %d times; want 1", f.scenario, f.teardowns)
+				}
+			}
+		})
+	}
+}
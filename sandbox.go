@@ -0,0 +1,153 @@
+package testmatrix
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Sandboxed gives each fixture its own Sandbox: a fresh temporary directory
+// holding a home directory, a temporary directory and XDG base directories,
+// so that tests in different scenarios cannot share state through files such
+// as ~/.gitconfig or ~/.docker.
+//
+// The sandbox is created before the fixture factory is called, and is shared
+// by tests sharing a fixture. Its environment is used by StartProcess and
+// included in ComposedFixture.Env; use SandboxOf to get it from other fixture
+// factories. The environment of the test binary itself is not changed, since
+// tests run in parallel.
+//
+// The sandbox is removed once its fixture has been torn down, unless the
+// fixture is kept with -tm.keep-failed.
+func Sandboxed() RunOption {
+	return func(o *runOptions) {
+		o.sandbox = true
+	}
+}
+
+// Sandbox is a per-fixture filesystem sandbox. See Sandboxed.
+type Sandbox struct {
+	// Root is the directory containing everything else in the sandbox.
+	Root string
+	// Home is used as HOME, and USERPROFILE on Windows.
+	Home string
+	// TempDir is used as TMPDIR, and TEMP and TMP on Windows.
+	TempDir string
+	// ConfigHome, CacheHome, DataHome and StateHome are used as the
+	// corresponding XDG base directories. They are in Home, where programs
+	// not following the XDG specification would put them.
+	ConfigHome, CacheHome, DataHome, StateHome string
+	// RuntimeDir is used as XDG_RUNTIME_DIR. Only the owner may access it.
+	RuntimeDir string
+}
+
+// SandboxOf returns the sandbox of the fixture of t, or nil if it has none
+// because Sandboxed was not used. t must be a test started by Runner.Run or
+// Runner.RunE, or a subtest of one.
+func SandboxOf(t *testing.T) *Sandbox {
+	_, rt, err := lookupRunningTest(t.Name())
+	if err != nil {
+		return nil
+	}
+	runningTestsMu.Lock()
+	defer runningTestsMu.Unlock()
+	return rt.sandbox
+}
+
+// newSandbox creates a sandbox in a new temporary directory.
+func newSandbox() (*Sandbox, error) {
+	root, err := os.MkdirTemp("", "testmatrix-sandbox-")
+	if err != nil {
+		return nil, err
+	}
+	home := filepath.Join(root, "home")
+	sb := &Sandbox{
+		Root:       root,
+		Home:       home,
+		TempDir:    filepath.Join(root, "tmp"),
+		ConfigHome: filepath.Join(home, ".config"),
+		CacheHome:  filepath.Join(home, ".cache"),
+		DataHome:   filepath.Join(home, ".local", "share"),
+		StateHome:  filepath.Join(home, ".local", "state"),
+		RuntimeDir: filepath.Join(root, "run"),
+	}
+	for _, dir := range []string{sb.TempDir, sb.ConfigHome, sb.CacheHome, sb.DataHome, sb.StateHome} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			os.RemoveAll(root)
+			return nil, err
+		}
+	}
+	if err := os.Mkdir(sb.RuntimeDir, 0700); err != nil {
+		os.RemoveAll(root)
+		return nil, err
+	}
+	return sb, nil
+}
+
+// Env returns the environment variables pointing into the sandbox, in the
+// form "key=value".
+func (sb *Sandbox) Env() []string {
+	return []string{
+		"HOME=" + sb.Home,
+		"USERPROFILE=" + sb.Home,
+		"TMPDIR=" + sb.TempDir,
+		"TEMP=" + sb.TempDir,
+		"TMP=" + sb.TempDir,
+		"XDG_CONFIG_HOME=" + sb.ConfigHome,
+		"XDG_CACHE_HOME=" + sb.CacheHome,
+		"XDG_DATA_HOME=" + sb.DataHome,
+		"XDG_STATE_HOME=" + sb.StateHome,
+		"XDG_RUNTIME_DIR=" + sb.RuntimeDir,
+	}
+}
+
+// remove removes the sandbox and everything in it.
+func (sb *Sandbox) remove() {
+	if err := os.RemoveAll(sb.Root); err != nil {
+		rtLog("ERROR: Removing sandbox %s: %s", sb.Root, err)
+	}
+}
+
+// sandboxed returns a FixtureFactoryE which creates a sandbox for the fixture
+// before calling makeFixture. The sandbox is owned by the fixture, so it is
+// removed when the fixture's owner is released.
+func sandboxed(makeFixture FixtureFactoryE) FixtureFactoryE {
+	return func(t *testing.T, c Scenario) (Fixture, error) {
+		_, rt, err := lookupRunningTest(t.Name())
+		if err != nil {
+			return nil, fmt.Errorf("creating sandbox: %w", err)
+		}
+		sb, err := newSandbox()
+		if err != nil {
+			return nil, fmt.Errorf("%w: creating sandbox: %s", ErrInfrastructure, err)
+		}
+		rt.sup.onRelease(ownerOf(rt), sb.remove)
+		setSandbox(t.Name(), sb)
+		return makeFixture(t, c)
+	}
+}
+
+// setSandbox sets the sandbox of the fixture of the test called name.
+func setSandbox(name string, sb *Sandbox) {
+	runningTestsMu.Lock()
+	defer runningTestsMu.Unlock()
+	if rt, ok := runningTests[name]; ok {
+		rt.sandbox = sb
+	}
+}
+
+// sandboxDescription returns a line naming the sandbox of the test called
+// name, to add to the description of its fixture, or "" if it has none.
+func sandboxDescription(name string) string {
+	_, rt, err := lookupRunningTest(name)
+	if err != nil {
+		return ""
+	}
+	runningTestsMu.Lock()
+	defer runningTestsMu.Unlock()
+	if rt.sandbox == nil {
+		return ""
+	}
+	return "\nsandbox: " + rt.sandbox.Root
+}
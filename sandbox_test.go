@@ -0,0 +1,64 @@
+package testmatrix
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRunner_Run_sandboxed(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name          string
+		options       []RunOption
+		wantSandboxes int
+	}{
+		{"none", nil, 0},
+		{"unshared", []RunOption{Sandboxed()}, 4},
+		{"shared", []RunOption{Sandboxed(), Shared()}, 2},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			m := New(makeTestDim(1, 2))
+			var mu sync.Mutex
+			sandboxes := map[*Sandbox]bool{}
+			test := func(t *testing.T, f Fixture) {
+				sb := f.(*ComposedFixture).Sandbox
+				if got := SandboxOf(t); got != sb {
+					t.Errorf("got SandboxOf %v; want %v", got, sb)
+				}
+				if sb == nil {
+					return
+				}
+				mu.Lock()
+				sandboxes[sb] = true
+				mu.Unlock()
+				for _, dir := range []string{sb.Home, sb.TempDir, sb.ConfigHome, sb.RuntimeDir} {
+					if _, err := os.Stat(dir); err != nil {
+						t.Error(err)
+					}
+				}
+				env := strings.Join(f.(*ComposedFixture).Env(), "\n")
+				if !strings.Contains(env, "HOME="+sb.Home+"\n") {
+					t.Errorf("got env %q; want it to set HOME to %s", env, sb.Home)
+				}
+			}
+			runGroup(t, "run", func(t *testing.T) {
+				r := m.NewRunner(t, tc.options...)
+				r.Run("a", nil, test)
+				r.Run("b", nil, test)
+			})
+			if len(sandboxes) != tc.wantSandboxes {
+				t.Errorf("got %d sandboxes; want %d", len(sandboxes), tc.wantSandboxes)
+			}
+			for sb := range sandboxes {
+				if _, err := os.Stat(sb.Root); !os.IsNotExist(err) {
+					t.Errorf("sandbox %s not removed after teardown: %v", sb.Root, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package testmatrix
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// RunOption configures a single call to Runner.Run, or when passed to
+// NewRunner, every call to Run on that Runner.
+type RunOption func(*runOptions)
+
+// runOptions are the options for a single call to Runner.Run.
+type runOptions struct {
+	shared      bool
+	sharedKey   string
+	prefetch    int
+	prefetchSet bool
+	// noPrefetch is set by Runner.Run, whose fixture factories can only
+	// report errors by calling t.Fatal, so must not be prefetched.
+	noPrefetch  bool
+	parallelism Parallelism
+	consumes    []resourceClaim
+	sandbox     bool
+	// sem limits the number of tests running at once. It is set by
+	// Runner.RunE according to the effective parallelism.
+	sem semaphore
+}
+
+func newRunOptions(options []RunOption) runOptions {
+	return runOptions{}.with(options)
+}
+
+// with returns a copy of o with options applied.
+func (o runOptions) with(options []RunOption) runOptions {
+	for _, opt := range options {
+		opt(&o)
+	}
+	return o
+}
+
+// Shared makes all tests in the same scenario under the same top-level test
+// share a single fixture, rather than each test getting its own. The fixture
+// is created by the first test in that scenario to start, and torn down when
+// the last test using it finishes. Serial tests share it too, though they do
+// not overlap; it is torn down once the test which ran them has finished.
+//
+// Shared fixtures must be safe for concurrent use by parallel tests.
+func Shared() RunOption {
+	return func(o *runOptions) {
+		o.shared = true
+		o.sharedKey = ""
+	}
+}
+
+// SharedKey is like Shared, except that the fixture is shared by all tests in
+// the same scenario using the same key, across all top-level tests. Only the
+// first fixture factory used with each key and scenario is invoked.
+//
+// Because top-level tests run concurrently, a fixture is only reused while
+// tests using it overlap. Once the last test using it finishes it is torn
+// down, and a later test with the same key and scenario creates it afresh.
+func SharedKey(key string) RunOption {
+	return func(o *runOptions) {
+		o.shared = true
+		o.sharedKey = key
+	}
+}
+
+// sharedFixture is a Fixture shared by all tests with the same key.
+type sharedFixture struct {
+	key string
+	// refs is the number of tests currently using this fixture.
+	// It is guarded by supervisor.sharedMu.
+	refs    int
+	once    sync.Once
+	created bool
+	// kept is true if any test using this fixture asked to keep it.
+	// It is guarded by supervisor.sharedMu.
+	kept    bool
+	fixture Fixture
+	sandbox *Sandbox
+	err     error
+}
+
+// retainShared returns the sharedFixture for key, with its reference count
+// incremented.
+func (s *supervisor) retainShared(key string) *sharedFixture {
+	s.sharedMu.Lock()
+	defer s.sharedMu.Unlock()
+	sf, ok := s.shared[key]
+	if !ok {
+		sf = &sharedFixture{key: key}
+		s.shared[key] = sf
+	}
+	sf.refs++
+	return sf
+}
+
+// releaseShared decrements the reference count of sf, and returns true if the
+// caller was the last user of a successfully created fixture, and so must
+// tear it down. If keep is true, or was true for any earlier caller, the
+// fixture is kept and releaseShared returns false.
+func (s *supervisor) releaseShared(sf *sharedFixture, keep bool) bool {
+	s.sharedMu.Lock()
+	defer s.sharedMu.Unlock()
+	sf.kept = sf.kept || keep
+	sf.refs--
+	if sf.refs != 0 {
+		return false
+	}
+	if s.shared[sf.key] == sf {
+		delete(s.shared, sf.key)
+	}
+	return sf.created && !sf.kept
+}
+
+// get returns the shared fixture, creating it using makeFixture if this is
+// the first test to use it. If creation failed, every test using it gets the
+// same error. Addresses, processes and sandboxes owned by it are held until it is torn
+// down, rather than until t ends.
+func (sf *sharedFixture) get(t *testing.T, c Scenario, makeFixture FixtureFactoryE, s *supervisor) (Fixture, error) {
+	sf.once.Do(func() {
+		defer setOwner(t.Name(), sf.owner())()
+		defer func() {
+			if !sf.created {
+				s.releaseOwner(sf.owner())
+			}
+		}()
+		sf.err = fmt.Errorf("shared fixture for scenario %s could not be created", c)
+		sf.fixture, sf.err = callFactory(t, c, makeFixture)
+		sf.created = sf.err == nil
+		if sf.created {
+			sf.sandbox = SandboxOf(t)
+		}
+	})
+	if sf.sandbox != nil {
+		setSandbox(t.Name(), sf.sandbox)
+	}
+	return sf.fixture, sf.err
+}
+
+// sharedKey returns the key of the fixture shared by tests of pf in scenario
+// c, run with options o.
+func (pf *Runner) sharedKey(c Scenario, o runOptions) string {
+	if o.sharedKey != "" {
+		return "key:" + o.sharedKey + "/" + c.String()
+	}
+	return pf.t.Name() + "/" + c.String()
+}
+
+// makeFixture returns a fixture for t, along with a func which must be called
+// once the test has finished using it, to tear it down if necessary. If that
+// func is passed keep=true, the fixture is not torn down.
+func (pf *Runner) makeFixture(t *testing.T, c Scenario, makeFixture FixtureFactoryE, o runOptions) (Fixture, func(context.Context, *testing.T, bool) error, error) {
+	if o.sandbox {
+		makeFixture = sandboxed(makeFixture)
+	}
+	if !o.shared {
+		fix, err := callFactory(t, c, makeFixture)
+		return fix, func(ctx context.Context, t *testing.T, keep bool) error {
+			if keep {
+				return nil
+			}
+			return pf.teardown(ctx, t, fix)
+		}, err
+	}
+	sf := pf.parent.retainShared(pf.sharedKey(c, o))
+	var ok bool
+	defer func() {
+		// Creating the fixture failed, so release it now since the caller
+		// will never get a chance to.
+		if !ok {
+			pf.parent.releaseShared(sf, false)
+		}
+	}()
+	fix, err := sf.get(t, c, makeFixture, pf.parent)
+	if err != nil {
+		return nil, nil, err
+	}
+	ok = true
+	return fix, func(ctx context.Context, t *testing.T, keep bool) error {
+		if pf.parent.releaseShared(sf, keep) {
+			defer pf.parent.releaseOwner(sf.owner())
+			return pf.teardown(ctx, t, fix)
+		}
+		return nil
+	}, nil
+}
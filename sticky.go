@@ -0,0 +1,52 @@
+package testmatrix
+
+// scenarioGroup is a set of scenarios sharing the same sticky values.
+type scenarioGroup struct {
+	// name is the sub-test path of the sticky values, or "" if the matrix
+	// has no sticky dimensions.
+	name      string
+	scenarios []Scenario
+}
+
+// groupScenarios groups scenarios by their sticky values, in order of each
+// group's first scenario. If m has no sticky dimensions, it returns a single
+// group with an empty name.
+func (m *Matrix) groupScenarios(scenarios []Scenario) []scenarioGroup {
+	if len(m.sticky) == 0 {
+		return []scenarioGroup{{scenarios: scenarios}}
+	}
+	var groups []scenarioGroup
+	index := map[string]int{}
+	for _, c := range scenarios {
+		sticky, _ := m.splitSticky(c)
+		name := sticky.String()
+		i, ok := index[name]
+		if !ok {
+			i = len(groups)
+			index[name] = i
+			groups = append(groups, scenarioGroup{name: name})
+		}
+		groups[i].scenarios = append(groups[i].scenarios, c)
+	}
+	return groups
+}
+
+// splitSticky splits c into the bindings of sticky dimensions and the rest,
+// each in dimension order.
+func (m *Matrix) splitSticky(c Scenario) (sticky, rest Scenario) {
+	for _, b := range c {
+		if m.sticky[b.Dimension] {
+			sticky = append(sticky, b)
+		} else {
+			rest = append(rest, b)
+		}
+	}
+	return sticky, rest
+}
+
+// scenarioPath returns the sub-test path of scenario c as run by m, which is
+// the sticky values followed by the rest.
+func (m *Matrix) scenarioPath(c Scenario) string {
+	sticky, rest := m.splitSticky(c)
+	return append(sticky, rest...).String()
+}
@@ -0,0 +1,81 @@
+package testmatrix
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMatrix_groupScenarios(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		dims []Dimension
+		want map[string][]string
+	}{
+		{
+			"none",
+			[]Dimension{makeTestDim(1, 2), makeTestDim(2, 2)},
+			map[string][]string{"": {"dim1val1/dim2val1", "dim1val1/dim2val2", "dim1val2/dim2val1", "dim1val2/dim2val2"}},
+		},
+		{
+			"second",
+			[]Dimension{makeTestDim(1, 2), makeTestDim(2, 2).Sticky()},
+			map[string][]string{
+				"dim2val1": {"dim1val1/dim2val1", "dim1val2/dim2val1"},
+				"dim2val2": {"dim1val1/dim2val2", "dim1val2/dim2val2"},
+			},
+		},
+		{
+			"all",
+			[]Dimension{makeTestDim(1, 1).Sticky(), makeTestDim(2, 2).Sticky()},
+			map[string][]string{
+				"dim1val1/dim2val1": {"dim1val1/dim2val1"},
+				"dim1val1/dim2val2": {"dim1val1/dim2val2"},
+			},
+		},
+	}
+	for _, tc := range cases {
+		m := New(tc.dims...)
+		got := map[string][]string{}
+		for _, g := range m.groupScenarios(m.scenarios()) {
+			got[g.name] = scenarioStrings(g.scenarios)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got groups %q; want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMatrix_scenarioPath(t *testing.T) {
+	t.Parallel()
+	m := New(makeTestDim(1, 1), makeTestDim(2, 1).Sticky(), makeTestDim(3, 1))
+	c := m.scenarios()[0]
+	if got, want := m.scenarioPath(c), "dim2val1/dim1val1/dim3val1"; got != want {
+		t.Errorf("got path %q; want %q", got, want)
+	}
+}
+
+func TestRunner_Run_sticky(t *testing.T) {
+	t.Parallel()
+	m := New(makeTestDim(1, 3), makeTestDim(2, 2).Sticky())
+	var mu sync.Mutex
+	var order []string
+	runGroup(t, "run", func(t *testing.T) {
+		r := m.NewRunner(t)
+		r.Run("test", nil, func(t *testing.T, f Fixture) {
+			c := f.(*ComposedFixture).Scenario
+			if want := "/" + m.scenarioPath(c) + "/test"; !strings.HasSuffix(t.Name(), want) {
+				t.Errorf("got test name %q; want suffix %q", t.Name(), want)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, c.Value("dim2").(string))
+		})
+	})
+	want := []string{"dim2val1", "dim2val1", "dim2val1", "dim2val2", "dim2val2", "dim2val2"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("got sticky values in order %q; want %q", order, want)
+	}
+}
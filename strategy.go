@@ -0,0 +1,98 @@
+package testmatrix
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Strategy is the name of a registered ScenarioGenerator.
+type Strategy string
+
+const (
+	// FullProduct is the name of the FullProductGenerator strategy, which is
+	// the default.
+	FullProduct Strategy = "full"
+	// BaseChoice is the name of the BaseChoiceGenerator strategy.
+	BaseChoice Strategy = "base-choice"
+)
+
+// strategies holds the ScenarioGenerators registered using RegisterStrategy.
+var strategies = struct {
+	sync.Mutex
+	generators map[Strategy]ScenarioGenerator
+}{generators: map[Strategy]ScenarioGenerator{
+	FullProduct: FullProductGenerator{},
+	BaseChoice:  BaseChoiceGenerator{},
+}}
+
+// RegisterStrategy makes g available by name, for use with WithStrategy and
+// the -tm.strategy flag. It panics if name is already registered.
+func RegisterStrategy(name Strategy, g ScenarioGenerator) {
+	strategies.Lock()
+	defer strategies.Unlock()
+	if _, ok := strategies.generators[name]; ok {
+		panic(fmt.Sprintf("duplicate strategy %q", name))
+	}
+	strategies.generators[name] = g
+}
+
+// lookupStrategy returns the generator registered as name.
+// It panics if there is none.
+func lookupStrategy(name Strategy) ScenarioGenerator {
+	strategies.Lock()
+	defer strategies.Unlock()
+	g, ok := strategies.generators[name]
+	if !ok {
+		var names []string
+		for n := range strategies.generators {
+			names = append(names, string(n))
+		}
+		sort.Strings(names)
+		panic(fmt.Sprintf("unknown strategy %q (registered strategies: %s)",
+			name, strings.Join(names, ", ")))
+	}
+	return g
+}
+
+// WithStrategy returns a new Matrix based on m that generates scenarios using
+// the named Strategy. The -tm.strategy flag overrides this if set.
+func (m Matrix) WithStrategy(s Strategy) Matrix {
+	m.strategy = s
+	m.generator = nil
+	return m
+}
+
+// WithGenerator returns a new Matrix based on m that generates scenarios using
+// g. The -tm.strategy flag overrides this if set.
+func (m Matrix) WithGenerator(g ScenarioGenerator) Matrix {
+	m.strategy = ""
+	m.generator = g
+	return m
+}
+
+// effectiveGenerator returns the generator named by the -tm.strategy flag, or
+// failing that the generator or strategy set on m, or failing that the
+// FullProductGenerator.
+func (m *Matrix) effectiveGenerator() ScenarioGenerator {
+	switch {
+	case *strategyFlag != "":
+		return lookupStrategy(Strategy(*strategyFlag))
+	case m.generator != nil:
+		return m.generator
+	case m.strategy != "":
+		return lookupStrategy(m.strategy)
+	}
+	return FullProductGenerator{}
+}
+
+// baseline returns the baseline scenario of m, and true, if the effective
+// generator is a BaselineGenerator. Otherwise it returns false.
+func (m *Matrix) baseline() (Scenario, bool) {
+	g, ok := m.effectiveGenerator().(BaselineGenerator)
+	if !ok {
+		return nil, false
+	}
+	return g.Baseline(m.dimensionList()), true
+}
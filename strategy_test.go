@@ -0,0 +1,141 @@
+package testmatrix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func scenarioStrings(scenarios []Scenario) []string {
+	s := make([]string, len(scenarios))
+	for i, c := range scenarios {
+		s[i] = c.String()
+	}
+	return s
+}
+
+func TestMatrix_scenarios_strategy(t *testing.T) {
+	t.Parallel()
+	dims := func() []Dimension {
+		return []Dimension{
+			Dim("a", "", Values{"a1": 1, "a2": 2, "a3": 3}).WithDefault("a2"),
+			Dim("b", "", Values{"b1": 1, "b2": 2}),
+		}
+	}
+	cases := []struct {
+		name string
+		in   Matrix
+		want []string
+	}{
+		{
+			"default",
+			New(dims()...),
+			[]string{"a1/b1", "a1/b2", "a2/b1", "a2/b2", "a3/b1", "a3/b2"},
+		},
+		{
+			"full",
+			New(dims()...).WithStrategy(FullProduct),
+			[]string{"a1/b1", "a1/b2", "a2/b1", "a2/b2", "a3/b1", "a3/b2"},
+		},
+		{
+			"base-choice",
+			New(dims()...).WithStrategy(BaseChoice),
+			[]string{"a2/b1", "a1/b1", "a3/b1", "a2/b2"},
+		},
+		{
+			"generator",
+			New(dims()...).WithGenerator(ScenarioGeneratorFunc(func(dims []Dimension) []Scenario {
+				return []Scenario{{dims[0].Binding("a3"), dims[1].Binding("b2")}}
+			})),
+			[]string{"a3/b2"},
+		},
+		{
+			"base-choice-fixed-default",
+			New(dims()...).WithStrategy(BaseChoice).FixedDimension("a", "a3"),
+			[]string{"a3/b1", "a3/b2"},
+		},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got := scenarioStrings(tc.in.scenarios())
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConcat_aliasing(t *testing.T) {
+	t.Parallel()
+	// prefix has spare capacity, so appending to it in place would make
+	// both results share their last binding.
+	prefix := make(Scenario, 1, 4)
+	prefix[0] = Binding{Dimension: "a", Name: "a1"}
+	x := concat([]Scenario{prefix, {{Dimension: "b", Name: "b1"}}})
+	y := concat([]Scenario{prefix, {{Dimension: "b", Name: "b2"}}})
+	if got, want := x.String(), "a1/b1"; got != want {
+		t.Errorf("got first scenario %q; want %q", got, want)
+	}
+	if got, want := y.String(), "a1/b2"; got != want {
+		t.Errorf("got second scenario %q; want %q", got, want)
+	}
+}
+
+func TestFullProductGenerator_manyDimensions(t *testing.T) {
+	t.Parallel()
+	m := New(makeTestDims(5, alwaysNValues(2))...)
+	got := scenarioStrings(m.scenarios())
+	seen := map[string]bool{}
+	for _, s := range got {
+		if seen[s] {
+			t.Errorf("duplicate scenario %q", s)
+		}
+		seen[s] = true
+	}
+	if len(seen) != 32 {
+		t.Errorf("got %d distinct scenarios; want 32", len(seen))
+	}
+}
+
+func TestRegisterStrategy(t *testing.T) {
+	t.Parallel()
+	first := ScenarioGeneratorFunc(func(dims []Dimension) []Scenario {
+		return []Scenario{{dims[0].Binding(dims[0].ValueNames()[0])}}
+	})
+	RegisterStrategy("test-first", first)
+	// Unregister the strategy afterwards, so the test can run again with
+	// -count, and other tests do not see it.
+	t.Cleanup(func() {
+		strategies.Lock()
+		defer strategies.Unlock()
+		delete(strategies.generators, "test-first")
+	})
+	m := New(Dim("a", "", Values{"x": 1, "y": 2})).WithStrategy("test-first")
+	if got, want := scenarioStrings(m.scenarios()), []string{"x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestMatrix_baseline(t *testing.T) {
+	t.Parallel()
+	m := FromStruct[struct {
+		A int    `values:"1,2,3" default:"3"`
+		B string `values:"x,y"`
+	}]()
+	want := Scenario{
+		{Dimension: "a", Name: "3", Value: 3},
+		{Dimension: "b", Name: "x", Value: "x"},
+	}
+	if _, ok := m.baseline(); ok {
+		t.Errorf("got baseline for full product strategy")
+	}
+	m = m.WithStrategy(BaseChoice)
+	got, ok := m.baseline()
+	if !ok {
+		t.Fatalf("got no baseline for base choice strategy")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
@@ -0,0 +1,204 @@
+package testmatrix
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FromStruct returns a new Matrix with one dimension per exported field of
+// the struct type C. Fields are configured using struct tags:
+//
+//	dim:"name"       the dimension name (defaults to the lower-cased field
+//	                 name; use "-" to skip the field)
+//	desc:"text"      the dimension description
+//	values:"a,b,c"   the allowed values, comma separated
+//	default:"a"      the default value name, see Dimension.WithDefault
+//	sticky:"true"    mark the dimension sticky, see Dimension.Sticky
+//
+// Each value listed in the values tag is parsed into the field's type, so
+// fields may be strings, bools, numbers, time.Durations, or any type whose
+// pointer implements encoding.TextUnmarshaler. Fields without a values tag
+// take their values from implementations of the field's type added using
+// Register, or if there are none and the field is a bool, default to both
+// "false" and "true".
+//
+// Dimensions are added in field order. Use Decode to turn a Scenario from the
+// resulting Matrix back into a populated C.
+//
+// FromStruct panics if C is not a struct or if any of its tags are invalid.
+func FromStruct[C any]() Matrix {
+	dims, err := structDimensions(reflect.TypeOf((*C)(nil)).Elem())
+	if err != nil {
+		panic(err)
+	}
+	return New(dims...)
+}
+
+// Decode returns a C populated from s. See Scenario.Decode.
+// Decode panics if s cannot be decoded into a C.
+func Decode[C any](s Scenario) C {
+	var c C
+	if err := s.Decode(&c); err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Decode sets each dimension field of the struct pointed to by dst to the
+// value bound to that dimension in this Scenario. Dimension fields are
+// identified the same way as in FromStruct. It returns an error if dst is not
+// a pointer to a struct, if any dimension is missing from the Scenario, or if
+// any value is not assignable to its field.
+func (c Scenario) Decode(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot decode scenario into %T: want pointer to struct", dst)
+	}
+	v = v.Elem()
+	values := c.Map()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, ok := structDimensionName(field)
+		if !ok {
+			continue
+		}
+		value, ok := values[name]
+		if !ok {
+			return fmt.Errorf("scenario contains no value for dimension %q (field %s)", name, field.Name)
+		}
+		if value == nil {
+			v.Field(i).Set(reflect.Zero(field.Type))
+			continue
+		}
+		rv := reflect.ValueOf(value)
+		if !rv.Type().AssignableTo(field.Type) {
+			return fmt.Errorf("value %v (%T) for dimension %q not assignable to field %s (%s)",
+				value, value, name, field.Name, field.Type)
+		}
+		v.Field(i).Set(rv)
+	}
+	return nil
+}
+
+// structDimensions returns a Dimension for each dimension field of t.
+func structDimensions(t reflect.Type) ([]Dimension, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot make dimensions from %s: not a struct", t)
+	}
+	var dims []Dimension
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := structDimensionName(field)
+		if !ok {
+			continue
+		}
+		values, err := structFieldValues(field)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %s", field.Name, err)
+		}
+		d := Dim(name, field.Tag.Get("desc"), values)
+		if def, ok := field.Tag.Lookup("default"); ok {
+			d = d.WithDefault(def)
+		}
+		if sticky, _ := strconv.ParseBool(field.Tag.Get("sticky")); sticky {
+			d = d.Sticky()
+		}
+		dims = append(dims, d)
+	}
+	return dims, nil
+}
+
+// structDimensionName returns the dimension name for field, and false if
+// field is not a dimension field.
+func structDimensionName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	name := field.Tag.Get("dim")
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return strings.ToLower(field.Name), true
+	}
+	return name, true
+}
+
+func structFieldValues(field reflect.StructField) (Values, error) {
+	tag, ok := field.Tag.Lookup("values")
+	if !ok {
+		if values := registeredValues(field.Type); len(values) != 0 {
+			return values, nil
+		}
+	}
+	if !ok && field.Type.Kind() == reflect.Bool {
+		tag, ok = "false,true", true
+	}
+	if !ok || tag == "" {
+		return nil, fmt.Errorf("no values tag")
+	}
+	values := Values{}
+	for _, name := range strings.Split(tag, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := values[name]; ok {
+			return nil, fmt.Errorf("duplicate value %q", name)
+		}
+		v, err := parseValue(field.Type, name)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = v
+	}
+	return values, nil
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+)
+
+// parseValue parses s into a new value of type t.
+func parseValue(t reflect.Type, s string) (interface{}, error) {
+	v := reflect.New(t).Elem()
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return nil, fmt.Errorf("parsing %q as %s: %s", s, t, err)
+		}
+		return v.Interface(), nil
+	}
+	var err error
+	switch {
+	case t == durationType:
+		var d time.Duration
+		d, err = time.ParseDuration(s)
+		v.SetInt(int64(d))
+	case t.Kind() == reflect.String:
+		v.SetString(s)
+	case t.Kind() == reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		v.SetBool(b)
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(s, 0, t.Bits())
+		v.SetInt(i)
+	case t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uintptr:
+		var u uint64
+		u, err = strconv.ParseUint(s, 0, t.Bits())
+		v.SetUint(u)
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(s, t.Bits())
+		v.SetFloat(f)
+	default:
+		return nil, fmt.Errorf("cannot parse values of type %s", t)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %q as %s: %s", s, t, err)
+	}
+	return v.Interface(), nil
+}
@@ -0,0 +1,143 @@
+package testmatrix
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testStructConfig struct {
+	Git     string        `desc:"version of git" values:"2.19.0,1.0.0" sticky:"true"`
+	Workers int           `dim:"n" values:"1,4"`
+	Verbose bool          `desc:"verbose output"`
+	Timeout time.Duration `values:"1s,1m"`
+	Addr    net.IP        `values:"127.0.0.1"`
+	Ignored string        `dim:"-"`
+	private string
+}
+
+func TestFromStruct(t *testing.T) {
+	t.Parallel()
+	m := FromStruct[testStructConfig]()
+	wantNames := []string{"git", "n", "verbose", "timeout", "addr"}
+	if !reflect.DeepEqual(m.orderedDimensionNames, wantNames) {
+		t.Fatalf("got dimensions %q; want %q", m.orderedDimensionNames, wantNames)
+	}
+	wantValues := Dimensions{
+		"git":     {"2.19.0": "2.19.0", "1.0.0": "1.0.0"},
+		"n":       {"1": 1, "4": 4},
+		"verbose": {"false": false, "true": true},
+		"timeout": {"1s": time.Second, "1m": time.Minute},
+		"addr":    {"127.0.0.1": net.ParseIP("127.0.0.1")},
+	}
+	if !reflect.DeepEqual(m.dimensions, wantValues) {
+		t.Errorf("got values %#v; want %#v", m.dimensions, wantValues)
+	}
+	if got, want := m.orderedDimensionDescs[0], "version of git"; got != want {
+		t.Errorf("got desc %q; want %q", got, want)
+	}
+	if want := map[string]bool{"git": true}; !reflect.DeepEqual(m.sticky, want) {
+		t.Errorf("got sticky %v; want %v", m.sticky, want)
+	}
+}
+
+func TestFromStruct_error(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name      string
+		fromFunc  func() Matrix
+		wantPanic string
+	}{
+		{"notstruct", func() Matrix {
+			return FromStruct[int]()
+		}, "not a struct"},
+		{"novalues", func() Matrix {
+			return FromStruct[struct{ A string }]()
+		}, "field A: no values tag"},
+		{"badvalue", func() Matrix {
+			return FromStruct[struct {
+				A int `values:"1,x"`
+			}]()
+		}, `field A: parsing "x" as int`},
+		{"dupevalue", func() Matrix {
+			return FromStruct[struct {
+				A int `values:"1,1"`
+			}]()
+		}, `field A: duplicate value "1"`},
+		{"badtype", func() Matrix {
+			return FromStruct[struct {
+				A []int `values:"1"`
+			}]()
+		}, "cannot parse values of type []int"},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			want := tc.wantPanic
+			defer func() {
+				gotPanic := recover()
+				if gotPanic == nil {
+					t.Fatalf("did not panic; want panic with %q", want)
+				}
+				got := fmt.Sprint(gotPanic)
+				if !strings.Contains(got, want) {
+					t.Errorf("got panic %q; want it to contain %q", got, want)
+				}
+			}()
+			tc.fromFunc()
+		})
+	}
+}
+
+func TestScenario_Decode(t *testing.T) {
+	t.Parallel()
+	m := FromStruct[testStructConfig]()
+	scenarios := m.scenarios()
+	if len(scenarios) != 16 {
+		t.Fatalf("got %d scenarios; want 16", len(scenarios))
+	}
+	for _, s := range scenarios {
+		got := Decode[testStructConfig](s)
+		want := testStructConfig{
+			Git:     s.Value("git").(string),
+			Workers: s.Value("n").(int),
+			Verbose: s.Value("verbose").(bool),
+			Timeout: s.Value("timeout").(time.Duration),
+			Addr:    s.Value("addr").(net.IP),
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v; want %+v", s, got, want)
+		}
+	}
+}
+
+func TestScenario_Decode_error(t *testing.T) {
+	t.Parallel()
+	s := Scenario{{Dimension: "git", Name: "1", Value: 1}}
+	cases := []struct {
+		name    string
+		dst     interface{}
+		wantErr string
+	}{
+		{"notpointer", testStructConfig{}, "want pointer to struct"},
+		{"missing", &struct{ Docker string }{}, `no value for dimension "docker"`},
+		{"unassignable", &struct{ Git string }{}, `not assignable to field Git (string)`},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := s.Decode(tc.dst)
+			if err == nil {
+				t.Fatalf("got nil error; want error containing %q", tc.wantErr)
+			}
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got error %q; want it to contain %q", err, tc.wantErr)
+			}
+		})
+	}
+}
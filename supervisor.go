@@ -1,23 +1,101 @@
 package testmatrix
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sort"
 	"sync"
 )
 
 // supervisor supervises a set of Runners, and collates their results.
 // There should be exactly one global supervisor per `go test` invocation.
 type supervisor struct {
-	mu       sync.Mutex
-	GetAddrs func(int) []string
-	fixtures map[string]*Runner
-	wg       sync.WaitGroup
+	// ctx is cancelled when the run is interrupted.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// testsCtx is the parent of the context of each test. It is cancelled
+	// when the run is interrupted, or when a test fails with
+	// -test.failfast.
+	testsCtx     context.Context
+	cancelTests  context.CancelFunc
+	failFastOnce sync.Once
+	// interruptedFlag is 1 if the run has been interrupted.
+	// It must be accessed atomically.
+	interruptedFlag int32
+	mu              sync.Mutex
+	fixtures        map[string]*Runner
+	// addrs allocates loopback addresses to fixtures.
+	addrs *addrAllocator
+	// owned holds funcs to call when each owner is released.
+	owned   map[string][]func()
+	ownedMu sync.Mutex
+	// wg counts fixture teardowns in progress.
+	wg sync.WaitGroup
+	// teardowns describes each teardown in progress, by an arbitrary ID.
+	teardowns      map[int]string
+	nextTeardownID int
+	teardownsMu    sync.Mutex
+	// hung describes each teardown that did not finish in time.
+	hung []string
+	// shared holds fixtures shared between tests, by key.
+	shared   map[string]*sharedFixture
+	sharedMu sync.Mutex
+	// prepared holds Preparable values currently in use, by dimension and
+	// value name.
+	prepared   map[string]*preparedValue
+	preparedMu sync.Mutex
+	// scenarios holds the state of scenarios with tests currently running,
+	// by scenario path.
+	scenarios   map[string]*scenarioState
+	scenariosMu sync.Mutex
+	// baselines is the set of baseline scenarios of matrices using a
+	// BaselineGenerator.
+	baselines map[string]struct{}
+	// artifacts holds the artifact directories created so far, by test name.
+	artifacts   map[string]artifactRecord
+	artifactsMu sync.Mutex
+	// artifactsDir is the root of all artifact directories, created once by
+	// artifactsRoot.
+	artifactsDir  string
+	artifactsErr  error
+	artifactsOnce sync.Once
+	// artifactsTemp is true if artifactsDir is a temporary directory created
+	// by artifactsRoot, which is removed if there is nothing to bundle.
+	artifactsTemp bool
+	// bundle is the path of the artifacts bundle, once written.
+	bundle string
+	// semaphores limit the number of tests running at once, by the scope of
+	// the parallelism setting.
+	semaphores   map[string]semaphore
+	semaphoresMu sync.Mutex
+	// resources limits the use of resources declared by Matrix.Resource, by
+	// name.
+	resources   map[string]*resourcePool
+	resourcesMu sync.Mutex
+	// pauseMu ensures only one failed test pauses at a time.
+	pauseMu sync.Mutex
 }
 
 func newSupervisor() *supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	testsCtx, cancelTests := context.WithCancel(ctx)
 	return &supervisor{
-		fixtures: map[string]*Runner{},
+		ctx:         ctx,
+		cancel:      cancel,
+		testsCtx:    testsCtx,
+		cancelTests: cancelTests,
+		fixtures:    map[string]*Runner{},
+		addrs:       newAddrAllocator(),
+		owned:       map[string][]func(){},
+		teardowns:   map[int]string{},
+		shared:      map[string]*sharedFixture{},
+		prepared:    map[string]*preparedValue{},
+		scenarios:   map[string]*scenarioState{},
+		baselines:   map[string]struct{}{},
+		artifacts:   map[string]artifactRecord{},
+		semaphores:  map[string]semaphore{},
+		resources:   map[string]*resourcePool{},
 	}
 }
 
@@ -26,29 +104,43 @@ func newSupervisor() *supervisor {
 // in each top-level TestXXX(t *testing.T) function in your package. Calling it
 // more than once per top-level test may cause undefined behaviour and may
 // panic.
-func (m *Matrix) NewRunner(t T) *Runner {
+//
+// Any RunOptions passed apply to every call to Run on the returned Runner.
+// Unless its parallelism is Serial, t is marked parallel.
+func (m *Matrix) NewRunner(t T, options ...RunOption) *Runner {
 	matrix := *m
 	if *printInfo {
 		scenarios := matrix.scenarios()
 		for _, s := range scenarios {
-			fmt.Printf("%s/%s\n", t.Name(), s)
+			fmt.Printf("%s/%s\n", t.Name(), matrix.scenarioPath(s))
 		}
 		t.Skip("Just printing test matrix.")
 	}
 	t.Helper()
-	t.Parallel()
 	r := &Runner{
-		t:                t,
-		matrix:           matrix,
-		testNames:        map[string]struct{}{},
-		testNamesPassed:  map[string]struct{}{},
-		testNamesSkipped: map[string]struct{}{},
-		testNamesFailed:  map[string]struct{}{},
-		parent:           m.sup,
+		t:                    t,
+		matrix:               matrix,
+		testNames:            map[string]struct{}{},
+		testNamesPassed:      map[string]struct{}{},
+		testNamesSkipped:     map[string]struct{}{},
+		testNamesFailed:      map[string]struct{}{},
+		testNamesFailedIn:    map[string]string{},
+		testNamesInfra:       map[string]string{},
+		testNamesInterrupted: map[string]struct{}{},
+		teardownFailures:     map[string]string{},
+		keptFixtures:         map[string]string{},
+		options:              newRunOptions(options),
+		parent:               m.sup,
+	}
+	if !r.runnerParallelism().serial {
+		t.Parallel()
 	}
 	m.sup.mu.Lock()
 	defer m.sup.mu.Unlock()
 	m.sup.fixtures[t.Name()] = r
+	if b, ok := matrix.baseline(); ok {
+		m.sup.baselines[b.String()] = struct{}{}
+	}
 	return r
 }
 
@@ -62,18 +154,30 @@ func (s *supervisor) TestCount() int {
 // PrintSummary prints a summary of tests run by top-level test and as a sum
 // total. It reports tests failed, skipped, passed, and missing (when a test has
 // failed to report back any status, which should not happen under normal
-// circumstances.
+// circumstances), as well as any failed teardowns.
 func (s *supervisor) PrintSummary() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	var total, passed, skipped, failed, missing []string
+	var total, passed, skipped, failed, infra, interrupted, missing, teardowns, kept []string
 	for _, pf := range s.fixtures {
-		t, p, s, f, m := pf.summary()
+		t, p, s, f, inf, i, m := pf.summary()
 		total = append(total, t...)
 		passed = append(passed, p...)
 		skipped = append(skipped, s...)
 		failed = append(failed, f...)
+		infra = append(infra, inf...)
+		interrupted = append(interrupted, i...)
 		missing = append(missing, m...)
+		teardowns = append(teardowns, pf.teardownFailureSlice()...)
+		kept = append(kept, pf.keptSlice()...)
+	}
+
+	if s.interrupted() {
+		fmt.Printf("The run was interrupted; this summary is partial.\n")
+		// Tests which did not report status were cut short by the
+		// interruption.
+		interrupted = append(interrupted, missing...)
+		missing = nil
 	}
 
 	if len(failed) != 0 {
@@ -83,6 +187,45 @@ func (s *supervisor) PrintSummary() {
 		}
 	}
 
+	if len(infra) != 0 {
+		sort.Strings(infra)
+		fmt.Printf("These tests had infrastructure errors:\n")
+		for _, n := range infra {
+			fmt.Printf("INFRA> %s\n", n)
+		}
+	}
+
+	if len(teardowns) != 0 {
+		sort.Strings(teardowns)
+		fmt.Printf("These tests failed to tear down:\n")
+		for _, n := range teardowns {
+			fmt.Printf("TEARDOWN> %s\n", n)
+		}
+	}
+
+	if len(kept) != 0 {
+		sort.Strings(kept)
+		fmt.Printf("These fixtures were kept for debugging:\n")
+		for _, n := range kept {
+			fmt.Printf("KEPT> %s\n", n)
+		}
+	}
+
+	if len(interrupted) != 0 {
+		sort.Strings(interrupted)
+		fmt.Printf("These tests were interrupted:\n")
+		for _, n := range interrupted {
+			fmt.Printf("INTERRUPTED> %s\n", n)
+		}
+	}
+
+	if len(s.hung) != 0 {
+		fmt.Printf("These teardowns did not finish:\n")
+		for _, n := range s.hung {
+			fmt.Printf("HUNG> %s\n", n)
+		}
+	}
+
 	if len(missing) != 0 {
 		fmt.Printf("These tests did not report status:\n")
 		for _, n := range missing {
@@ -93,8 +236,37 @@ func (s *supervisor) PrintSummary() {
 	// By default, don't print anything for 'missing' if all tests reported
 	// back. In general this should happen rarely so showing it is just noise.
 	var missingStr string
+	if len(infra) != 0 {
+		missingStr += fmt.Sprintf("%d infrastructure errors ", len(infra))
+	}
+	if len(interrupted) != 0 {
+		missingStr += fmt.Sprintf("%d interrupted ", len(interrupted))
+	}
 	if len(missing) != 0 {
-		missingStr = fmt.Sprintf("%d missing ", len(missing))
+		missingStr += fmt.Sprintf("%d missing ", len(missing))
+	}
+	if len(teardowns) != 0 {
+		missingStr += fmt.Sprintf("%d teardowns failed ", len(teardowns))
+	}
+	if len(kept) != 0 {
+		missingStr += fmt.Sprintf("%d fixtures kept ", len(kept))
+	}
+	if len(s.hung) != 0 {
+		missingStr += fmt.Sprintf("%d teardowns hung ", len(s.hung))
+	}
+
+	baselines := testNamesSlice(s.baselines)
+	sort.Strings(baselines)
+	for _, b := range baselines {
+		fmt.Printf("Baseline scenario: %s\n", b)
+	}
+
+	for _, r := range s.resourceSlice() {
+		fmt.Printf("RESOURCE> %s\n", r)
+	}
+
+	if s.bundle != "" {
+		fmt.Printf("Artifacts bundle: %s\n", s.bundle)
 	}
 
 	summary := fmt.Sprintf("Summary: %d failed; %d skipped; %d passed; %s(total %d)",
@@ -0,0 +1,291 @@
+package testmatrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ContextTearableDown is a kind of Fixture that can be torn down after a test
+// has finished, within the deadline of ctx. Any error returned fails the test
+// and is listed in the summary. If both ContextTearableDown and TearableDown
+// are implemented, only TeardownContext is called.
+type ContextTearableDown interface {
+	TeardownContext(ctx context.Context) error
+}
+
+// CleanupStack is a stack of cleanup funcs, which run in LIFO order when it is
+// torn down. It implements ContextTearableDown, so embedding a CleanupStack in
+// your fixture is enough to have its cleanups run after each test.
+// The zero value is ready to use.
+type CleanupStack struct {
+	mu    sync.Mutex
+	funcs []func(context.Context) error
+}
+
+// Push adds f to the top of the stack.
+func (s *CleanupStack) Push(f func(context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.funcs = append(s.funcs, f)
+}
+
+// TeardownContext pops and calls every cleanup func, most recently pushed
+// first. All funcs are called even if some fail; if more than one fails, the
+// returned error combines all of their errors.
+func (s *CleanupStack) TeardownContext(ctx context.Context) error {
+	var errs cleanupErrors
+	for {
+		s.mu.Lock()
+		if len(s.funcs) == 0 {
+			s.mu.Unlock()
+			break
+		}
+		f := s.funcs[len(s.funcs)-1]
+		s.funcs = s.funcs[:len(s.funcs)-1]
+		s.mu.Unlock()
+		if err := f(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return errs
+}
+
+// cleanupErrors is the error returned when more than one cleanup func fails.
+type cleanupErrors []error
+
+func (errs cleanupErrors) Error() string {
+	s := make([]string, len(errs))
+	for i, err := range errs {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// teardownTimeout returns the configured teardown timeout.
+func teardownTimeout() time.Duration {
+	switch {
+	case *teardownTimeoutFlag != 0:
+		return *teardownTimeoutFlag
+	case opts.TeardownTimeout != 0:
+		return opts.TeardownTimeout
+	}
+	return defaultTeardownTimeout
+}
+
+// teardownContext returns a context carrying the teardown deadline.
+func teardownContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), teardownTimeout())
+}
+
+func (pf *Runner) teardown(ctx context.Context, t *testing.T, f Fixture) error {
+	switch tear := f.(type) {
+	case ContextTearableDown:
+		return tear.TeardownContext(ctx)
+	case TearableDown:
+		tear.Teardown(t)
+	}
+	return nil
+}
+
+// errTeardownExited is the error of a teardown which called runtime.Goexit,
+// usually via t.FailNow.
+var errTeardownExited = errors.New("teardown exited its goroutine")
+
+// teardownNeedsT returns true if tearing down f needs the *testing.T of its
+// test, because f only implements TearableDown.
+func teardownNeedsT(f Fixture) bool {
+	if _, ok := f.(ContextTearableDown); ok {
+		return false
+	}
+	_, ok := f.(TearableDown)
+	return ok
+}
+
+// runTeardown calls teardown in another goroutine to tear down fix with a
+// context carrying the teardown deadline, and fails t if it returns an error
+// or does not finish in time. A teardown which does not finish in time keeps
+// running in the background after t has finished, and is waited for by
+// Matrix.Run, so teardown is only passed t if fix needs it; see
+// TearableDown. tr tracks when it has really finished.
+func (pf *Runner) runTeardown(t *testing.T, c Scenario, fix Fixture, tr *teardownTracker, teardown func(context.Context, *testing.T) error) {
+	t.Helper()
+	ctx, cancel := teardownContext()
+	defer cancel()
+	finished := pf.parent.startTeardown(t.Name(), c)
+	tr.start()
+	var teardownT *testing.T
+	if teardownNeedsT(fix) {
+		teardownT = t
+	}
+	done := make(chan error, 1)
+	go func() {
+		// If teardown calls t.FailNow, only deferred calls are run.
+		err := errTeardownExited
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+			done <- err
+			tr.finish()
+			finished()
+		}()
+		err = teardown(ctx, teardownT)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		select {
+		case err = <-done:
+		default:
+			err = fmt.Errorf("did not finish within %s", teardownTimeout())
+			rtLog("ERROR: Teardown of %s took longer than %s", t.Name(), teardownTimeout())
+		}
+	}
+	pf.teardownFinished(t, c, err)
+}
+
+// teardownFinished fails t if its teardown returned err.
+func (pf *Runner) teardownFinished(t *testing.T, c Scenario, err error) {
+	t.Helper()
+	if err != nil {
+		t.Errorf("teardown of scenario %s: %s", c, err)
+		pf.recordTeardownFailure(t.Name(), err)
+	}
+}
+
+// teardownTracker tracks whether the teardown of a test's fixture is still
+// running, possibly after the test has finished, so that the resources,
+// addresses, processes and sandbox the fixture uses are only released once
+// it has finished. The zero value is not running.
+type teardownTracker struct {
+	mu      sync.Mutex
+	running bool
+	after   []func()
+}
+
+// start records that the teardown has started.
+func (tr *teardownTracker) start() {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.running = true
+}
+
+// finish records that the teardown has finished, and calls the funcs passed
+// to whenDone while it was running, in the order they were passed.
+func (tr *teardownTracker) finish() {
+	tr.mu.Lock()
+	after := tr.after
+	tr.running, tr.after = false, nil
+	tr.mu.Unlock()
+	for _, f := range after {
+		f()
+	}
+}
+
+// whenDone calls f once the teardown has finished, or now if it is not
+// running.
+func (tr *teardownTracker) whenDone(f func()) {
+	tr.mu.Lock()
+	if tr.running {
+		tr.after = append(tr.after, f)
+		tr.mu.Unlock()
+		return
+	}
+	tr.mu.Unlock()
+	f()
+}
+
+func (pf *Runner) recordTeardownFailure(name string, err error) {
+	pf.teardownFailuresMu.Lock()
+	defer pf.teardownFailuresMu.Unlock()
+	pf.teardownFailures[name] = err.Error()
+}
+
+// teardownFailureSlice returns a description of each failed teardown.
+func (pf *Runner) teardownFailureSlice() []string {
+	pf.teardownFailuresMu.Lock()
+	defer pf.teardownFailuresMu.Unlock()
+	var s []string
+	for name, err := range pf.teardownFailures {
+		s = append(s, name+": "+err)
+	}
+	return s
+}
+
+// teardownWaitTimeout returns the configured deadline for waiting for
+// outstanding teardowns at the end of the run.
+func teardownWaitTimeout() time.Duration {
+	switch {
+	case *teardownWaitFlag != 0:
+		return *teardownWaitFlag
+	case opts.TeardownWaitTimeout != 0:
+		return opts.TeardownWaitTimeout
+	}
+	return defaultTeardownTimeout
+}
+
+// startTeardown records that the teardown of the named test in scenario c has
+// started. The returned func must be called once it has finished.
+func (s *supervisor) startTeardown(name string, c Scenario) func() {
+	s.wg.Add(1)
+	s.teardownsMu.Lock()
+	defer s.teardownsMu.Unlock()
+	id := s.nextTeardownID
+	s.nextTeardownID++
+	s.teardowns[id] = fmt.Sprintf("%s (scenario %s)", name, c)
+	return func() {
+		s.teardownsMu.Lock()
+		delete(s.teardowns, id)
+		s.teardownsMu.Unlock()
+		s.wg.Done()
+	}
+}
+
+// waitTeardowns waits up to timeout for all outstanding teardowns to finish.
+// It returns a description of each teardown which did not, sorted by test
+// name. These are also listed in the summary.
+func (s *supervisor) waitTeardowns(timeout time.Duration) []string {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	var hung []string
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		s.teardownsMu.Lock()
+		for _, desc := range s.teardowns {
+			hung = append(hung, desc)
+		}
+		s.teardownsMu.Unlock()
+		sort.Strings(hung)
+	}
+	s.mu.Lock()
+	s.hung = hung
+	s.mu.Unlock()
+	return hung
+}
+
+// WaitForTeardowns waits for all fixture teardowns still in progress to
+// finish, for up to Opts.TeardownWaitTimeout (or -tm.teardown-wait). It
+// returns false if any did not finish in time, in which case they are listed
+// in the summary.
+//
+// If using the Run func, you don't need to additionally call this.
+func (m *Matrix) WaitForTeardowns() bool {
+	return len(m.sup.waitTeardowns(teardownWaitTimeout())) == 0
+}
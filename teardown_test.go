@@ -0,0 +1,167 @@
+package testmatrix
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCleanupStack_TeardownContext(t *testing.T) {
+	t.Parallel()
+	var s CleanupStack
+	var order []int
+	for i := 1; i <= 3; i++ {
+		i := i
+		s.Push(func(ctx context.Context) error {
+			order = append(order, i)
+			if i != 2 {
+				return errors.New("failed " + string(rune('0'+i)))
+			}
+			return nil
+		})
+	}
+	err := s.TeardownContext(context.Background())
+	if want := []int{3, 2, 1}; !reflect.DeepEqual(order, want) {
+		t.Errorf("got order %v; want %v", order, want)
+	}
+	if err == nil || err.Error() != "failed 3; failed 1" {
+		t.Errorf("got error %v; want %q", err, "failed 3; failed 1")
+	}
+	if err := s.TeardownContext(context.Background()); err != nil {
+		t.Errorf("second teardown got error %v; want nil", err)
+	}
+	if len(order) != 3 {
+		t.Errorf("second teardown ran cleanups again")
+	}
+}
+
+func TestCleanupStack_context(t *testing.T) {
+	t.Parallel()
+	var s CleanupStack
+	s.Push(func(ctx context.Context) error {
+		return ctx.Err()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.TeardownContext(ctx); err != context.Canceled {
+		t.Errorf("got error %v; want %v", err, context.Canceled)
+	}
+}
+
+func TestSupervisor_waitTeardowns(t *testing.T) {
+	t.Parallel()
+	s := newSupervisor()
+	c := Scenario{{Dimension: "dim1", Name: "dim1val1"}}
+	finishedA := s.startTeardown("TestA", c)
+	finishedB := s.startTeardown("TestB", c)
+	finishedA()
+
+	want := []string{"TestB (scenario dim1val1)"}
+	if got := s.waitTeardowns(time.Millisecond); !reflect.DeepEqual(got, want) {
+		t.Errorf("got hung %q; want %q", got, want)
+	}
+	if !reflect.DeepEqual(s.hung, want) {
+		t.Errorf("got supervisor hung %q; want %q", s.hung, want)
+	}
+
+	finishedB()
+	if got := s.waitTeardowns(time.Second); got != nil {
+		t.Errorf("got hung %q after all finished; want none", got)
+	}
+}
+
+// fatalFixture is a legacy fixture whose Teardown calls t.Fatal.
+type fatalFixture struct{}
+
+func (fatalFixture) Teardown(t *testing.T) { t.Fatal("teardown fatal") }
+
+func TestHelper_teardownFatal(t *testing.T) {
+	helperTest(t)
+	m := New(makeTestDim(1, 1))
+	r := m.NewRunner(t)
+	r.Run("test", func(*testing.T, Scenario) Fixture { return fatalFixture{} }, func(*testing.T, Fixture) {})
+}
+
+func TestRunTeardown_fatal(t *testing.T) {
+	t.Parallel()
+	start := time.Now()
+	out := runHelperTest(t, "TestHelper_teardownFatal")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %s; want the test to finish as soon as Teardown called t.Fatal", elapsed)
+	}
+	for _, want := range []string{"teardown fatal", "--- FAIL: TestHelper_teardownFatal/dim1val1/test"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got output:\n%s\nwant it to contain %q", out, want)
+		}
+	}
+	if strings.Contains(out, "did not finish") {
+		t.Errorf("got output:\n%s\nwant no teardown timeout", out)
+	}
+}
+
+// slowLegacyFixture is a legacy fixture whose Teardown ignores its deadline.
+type slowLegacyFixture struct{}
+
+func (slowLegacyFixture) Teardown(t *testing.T) { time.Sleep(300 * time.Millisecond) }
+
+func TestHelper_legacyTeardownTimeout(t *testing.T) {
+	helperTest(t)
+	opts.TeardownTimeout = 50 * time.Millisecond
+	m := New(makeTestDim(1, 1))
+	r := m.NewRunner(t)
+	r.Run("test", func(*testing.T, Scenario) Fixture { return slowLegacyFixture{} }, func(*testing.T, Fixture) {})
+}
+
+func TestRunTeardown_legacyTimeout(t *testing.T) {
+	t.Parallel()
+	out := runHelperTest(t, "TestHelper_legacyTeardownTimeout")
+	for _, want := range []string{
+		"did not finish within 50ms",
+		"--- FAIL: TestHelper_legacyTeardownTimeout/dim1val1/test",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got output:\n%s\nwant it to contain %q", out, want)
+		}
+	}
+}
+
+// slowFixture is a fixture whose teardown ignores its deadline. live counts
+// the slowFixtures not yet torn down.
+type slowFixture struct {
+	live *int32
+}
+
+func (f slowFixture) TeardownContext(ctx context.Context) error {
+	time.Sleep(300 * time.Millisecond)
+	atomic.AddInt32(f.live, -1)
+	return nil
+}
+
+func TestHelper_teardownTimeout(t *testing.T) {
+	helperTest(t)
+	opts.TeardownTimeout = 50 * time.Millisecond
+	m := New(makeTestDim(1, 2)).Resource("db", 1)
+	var live int32
+	r := m.NewRunner(t, WithParallelism(Parallel))
+	r.Run("test", func(t *testing.T, s Scenario) Fixture {
+		if atomic.AddInt32(&live, 1) > 1 {
+			t.Error("resource released before the previous teardown finished")
+		}
+		return slowFixture{&live}
+	}, func(*testing.T, Fixture) {}, Consumes("db", 1))
+}
+
+func TestRunTeardown_timeout(t *testing.T) {
+	t.Parallel()
+	out := runHelperTest(t, "TestHelper_teardownTimeout", "-test.parallel=2")
+	if !strings.Contains(out, "did not finish within 50ms") {
+		t.Errorf("got output:\n%s\nwant a teardown timeout", out)
+	}
+	if strings.Contains(out, "resource released before") {
+		t.Errorf("got output:\n%s\nwant the resource held until teardown finished", out)
+	}
+}
@@ -2,9 +2,36 @@ package testmatrix
 
 import (
 	"fmt"
+	"os"
+	"os/exec"
 	"testing"
 )
 
+// helperTestEnv names the helper test being run by runHelperTest.
+const helperTestEnv = "TESTMATRIX_HELPER_TEST"
+
+// runHelperTest runs the named helper test in a new process running this test
+// binary, with any extra args, and returns its output. Use it to check the
+// results of tests which are expected to fail, without failing the calling
+// test.
+func runHelperTest(t *testing.T, name string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^" + name + "$", "-test.v"}, args...)...)
+	cmd.Env = append(os.Environ(), helperTestEnv+"="+name)
+	out, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatalf("running %s: %s", name, err)
+	}
+	return string(out)
+}
+
+// helperTest skips t unless it is being run by runHelperTest.
+func helperTest(t *testing.T) {
+	if os.Getenv(helperTestEnv) != t.Name() {
+		t.Skip("helper test, only run by runHelperTest")
+	}
+}
+
 func makeTestDims(count int, valueCountFunc func(index int) (valueCount int)) []Dimension {
 	ds := make([]Dimension, count)
 	for i := 0; i < count; i++ {
@@ -0,0 +1,89 @@
+package testmatrix
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// SliceValues returns Values containing each item, named by the name func.
+// It panics if two items have the same name.
+func SliceValues[V any](items []V, name func(V) string) Values {
+	values := make(Values, len(items))
+	for _, item := range items {
+		n := name(item)
+		if _, ok := values[n]; ok {
+			panic(fmt.Sprintf("duplicate value name %q", n))
+		}
+		values[n] = item
+	}
+	return values
+}
+
+// IntRange returns Values for each int from first to last inclusive, in
+// increments of step. Each value is named by its decimal representation.
+// It panics if step is not positive.
+func IntRange(first, last, step int) Values {
+	if step <= 0 {
+		panic(fmt.Sprintf("IntRange step must be positive; got %d", step))
+	}
+	values := Values{}
+	for i := first; i <= last; i += step {
+		values[strconv.Itoa(i)] = i
+		// Stop before i += step overflows.
+		if i > last-step {
+			break
+		}
+	}
+	return values
+}
+
+// StringerValues returns Values containing each item, named by its String
+// method. This is useful for enums. It panics if two items have the same name.
+func StringerValues[S fmt.Stringer](items ...S) Values {
+	return SliceValues(items, S.String)
+}
+
+// registry holds implementations registered using Register, keyed by the type
+// they were registered as.
+var registry = struct {
+	sync.Mutex
+	impls map[reflect.Type]Values
+}{impls: map[reflect.Type]Values{}}
+
+// Register adds impl to the package-level registry of implementations of I,
+// under the given name. It is designed to be called from init funcs, so that
+// new implementations automatically join any matrix built using Registered.
+// It panics if name is already registered for I.
+func Register[I any](name string, impl I) {
+	t := reflect.TypeOf((*I)(nil)).Elem()
+	registry.Lock()
+	defer registry.Unlock()
+	values, ok := registry.impls[t]
+	if !ok {
+		values = Values{}
+		registry.impls[t] = values
+	}
+	if _, ok := values[name]; ok {
+		panic(fmt.Sprintf("duplicate registration of %s %q", t, name))
+	}
+	values[name] = impl
+}
+
+// Registered returns Values containing all implementations of I added using
+// Register. Because package-level variables are initialised before init
+// funcs run, it should be called after init, e.g. from TestMain.
+func Registered[I any]() Values {
+	return registeredValues(reflect.TypeOf((*I)(nil)).Elem())
+}
+
+func registeredValues(t reflect.Type) Values {
+	registry.Lock()
+	defer registry.Unlock()
+	values := make(Values, len(registry.impls[t]))
+	for n, v := range registry.impls[t] {
+		values[n] = v
+	}
+	return values
+}
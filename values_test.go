@@ -0,0 +1,98 @@
+package testmatrix
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type testColour int
+
+func (c testColour) String() string {
+	return [...]string{"red", "green", "blue"}[c]
+}
+
+func TestValuesConstructors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		got  Values
+		want Values
+	}{
+		{"SliceValues", SliceValues([]string{"a", "bb"}, func(s string) string {
+			return strings.ToUpper(s)
+		}), Values{"A": "a", "BB": "bb"}},
+		{"IntRange", IntRange(1, 3, 1), Values{"1": 1, "2": 2, "3": 3}},
+		{"IntRange/step", IntRange(0, 10, 5), Values{"0": 0, "5": 5, "10": 10}},
+		{"IntRange/empty", IntRange(1, 0, 1), Values{}},
+		{"IntRange/max", IntRange(math.MaxInt-1, math.MaxInt, 1),
+			Values{strconv.Itoa(math.MaxInt - 1): math.MaxInt - 1, strconv.Itoa(math.MaxInt): math.MaxInt}},
+		{"IntRange/max-step", IntRange(math.MaxInt-2, math.MaxInt, 2),
+			Values{strconv.Itoa(math.MaxInt - 2): math.MaxInt - 2, strconv.Itoa(math.MaxInt): math.MaxInt}},
+		{"StringerValues", StringerValues(testColour(0), testColour(2)),
+			Values{"red": testColour(0), "blue": testColour(2)}},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if !reflect.DeepEqual(tc.got, tc.want) {
+				t.Errorf("got %#v; want %#v", tc.got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSliceValues_duplicate(t *testing.T) {
+	t.Parallel()
+	want := `duplicate value name "x"`
+	defer func() {
+		got := fmt.Sprint(recover())
+		if got != want {
+			t.Errorf("got panic %q; want %q", got, want)
+		}
+	}()
+	SliceValues([]int{1, 2}, func(int) string { return "x" })
+}
+
+type testRegisteredIface interface{ ID() int }
+
+type testRegisteredImpl int
+
+func (i testRegisteredImpl) ID() int { return int(i) }
+
+func TestRegister(t *testing.T) {
+	t.Parallel()
+	// Unregister testRegisteredIface afterwards, so the test can run again
+	// with -count.
+	t.Cleanup(func() {
+		registry.Lock()
+		defer registry.Unlock()
+		delete(registry.impls, reflect.TypeOf((*testRegisteredIface)(nil)).Elem())
+	})
+	Register[testRegisteredIface]("one", testRegisteredImpl(1))
+	Register[testRegisteredIface]("two", testRegisteredImpl(2))
+
+	want := Values{"one": testRegisteredImpl(1), "two": testRegisteredImpl(2)}
+	if got := Registered[testRegisteredIface](); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+
+	m := FromStruct[struct{ Impl testRegisteredIface }]()
+	if got := m.dimensions["impl"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got FromStruct values %#v; want %#v", got, want)
+	}
+
+	func() {
+		defer func() {
+			got := fmt.Sprint(recover())
+			if !strings.Contains(got, `duplicate registration`) {
+				t.Errorf("got panic %q; want duplicate registration", got)
+			}
+		}()
+		Register[testRegisteredIface]("one", testRegisteredImpl(3))
+	}()
+}